@@ -0,0 +1,265 @@
+// Command automemlimit prints the memory limit detected by automemlimit and how it was discovered.
+// It is intended to be run inside a container to debug the memory limit without reading the cgroupfs by hand.
+//
+//	automemlimit [-ratio 0.9] [-swap] [-json]
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/KimMachineGun/automemlimit/memlimit"
+)
+
+func main() {
+	if err := run(os.Stdout, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
+
+// report is the result of the discovery.
+type report struct {
+	Cgroup     cgroupReport     `json:"cgroup"`
+	Providers  []providerReport `json:"providers"`
+	Ratio      float64          `json:"ratio"`
+	Limit      uint64           `json:"limit"`
+	GOMEMLIMIT uint64           `json:"gomemlimit"`
+}
+
+type cgroupReport struct {
+	V1           bool          `json:"v1"`
+	V2           bool          `json:"v2"`
+	Version      int           `json:"version"`
+	Mount        mountReport   `json:"mount"`
+	Candidates   []mountReport `json:"candidates,omitempty"`
+	CgroupLine   string        `json:"cgroup_line"`
+	CgroupPath   string        `json:"cgroup_path"`
+	ResolvedPath string        `json:"resolved_path"`
+	Resolution   string        `json:"resolution"`
+	Levels       []levelReport `json:"levels"`
+	Winner       int           `json:"winner"`
+	Limit        uint64        `json:"limit"`
+	SwapLimit    uint64        `json:"swap_limit"`
+	Error        string        `json:"error,omitempty"`
+}
+
+type mountReport struct {
+	Root           string `json:"root"`
+	MountPoint     string `json:"mount_point"`
+	FilesystemType string `json:"filesystem_type"`
+	SuperOptions   string `json:"super_options"`
+}
+
+type levelReport struct {
+	Path        string `json:"path"`
+	Limit       uint64 `json:"limit"`
+	Limited     bool   `json:"limited"`
+	File        string `json:"file,omitempty"`
+	Swap        uint64 `json:"swap"`
+	SwapLimited bool   `json:"swap_limited"`
+}
+
+type providerReport struct {
+	Name  string `json:"name"`
+	Limit uint64 `json:"limit"`
+	Error string `json:"error,omitempty"`
+}
+
+func run(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("automemlimit", flag.ContinueOnError)
+	fs.SetOutput(w)
+	ratio := fs.Float64("ratio", 0.9, "ratio of the memory limit to set as GOMEMLIMIT")
+	swap := fs.Bool("swap", false, "count the swap limit in the memory limit (CgroupOptions.IncludeSwap)")
+	asJSON := fs.Bool("json", false, "print the result as JSON")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			// the usage has been printed.
+			return nil
+		}
+		return err
+	}
+	if *ratio <= 0 || *ratio > 1 {
+		return fmt.Errorf("invalid ratio: %f, ratio should be in the range (0.0,1.0]", *ratio)
+	}
+
+	r := discover(*ratio, *swap)
+	if *asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	return printReport(w, r)
+}
+
+// discover runs the same discovery as the providers of automemlimit.
+// If includeSwap is true, the swap limit is counted in the cgroup memory limit.
+func discover(ratio float64, includeSwap bool) report {
+	inspect, fromCgroup, name := memlimit.InspectCgroup, memlimit.Provider(memlimit.FromCgroup), "FromCgroup"
+	if includeSwap {
+		opts := memlimit.CgroupOptions{IncludeSwap: true}
+		inspect = func() (*memlimit.CgroupInfo, error) {
+			return memlimit.InspectCgroupWithOptions(opts)
+		}
+		fromCgroup, name = memlimit.NewCgroupProvider(opts), "FromCgroup (IncludeSwap)"
+	}
+
+	info, err := inspect()
+	r := report{
+		Cgroup: newCgroupReport(info, err),
+		Ratio:  ratio,
+	}
+
+	for _, p := range []struct {
+		name     string
+		provider memlimit.Provider
+	}{
+		{name, fromCgroup},
+		{"FromCgroupV1", memlimit.FromCgroupV1},
+		{"FromCgroupV2", memlimit.FromCgroupV2},
+		{"FromSystem", memlimit.FromSystem},
+	} {
+		limit, err := p.provider()
+		pr := providerReport{Name: p.name, Limit: limit}
+		if err != nil {
+			pr.Error = err.Error()
+		}
+		r.Providers = append(r.Providers, pr)
+	}
+
+	// the limit that SetGoMemLimit would use, i.e. FromCgroup, or NewCgroupProvider with IncludeSwap.
+	if r.Providers[0].Error == "" {
+		r.Limit = r.Providers[0].Limit
+		r.GOMEMLIMIT, _ = memlimit.ApplyRatio(memlimit.Limit(r.Limit), ratio)()
+	}
+
+	return r
+}
+
+func newCgroupReport(info *memlimit.CgroupInfo, err error) cgroupReport {
+	r := cgroupReport{
+		V1:           info.V1,
+		V2:           info.V2,
+		Version:      info.Version,
+		Mount:        newMountReport(info.Mount),
+		CgroupLine:   info.CgroupLine,
+		CgroupPath:   info.CgroupPath,
+		ResolvedPath: info.ResolvedPath,
+		Resolution:   info.Resolution,
+		Winner:       info.Winner,
+		Limit:        info.Limit,
+		SwapLimit:    info.SwapLimit,
+	}
+	for _, m := range info.Candidates {
+		r.Candidates = append(r.Candidates, newMountReport(m))
+	}
+	for _, l := range info.Levels {
+		r.Levels = append(r.Levels, levelReport{
+			Path:        l.Path,
+			Limit:       l.Limit,
+			Limited:     l.Limited,
+			File:        l.File,
+			Swap:        l.Swap,
+			SwapLimited: l.SwapLimited,
+		})
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+func newMountReport(m memlimit.CgroupMount) mountReport {
+	return mountReport{
+		Root:           m.Root,
+		MountPoint:     m.MountPoint,
+		FilesystemType: m.FilesystemType,
+		SuperOptions:   m.SuperOptions,
+	}
+}
+
+// printReport prints the report in a human-readable form.
+func printReport(w io.Writer, r report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	c := r.Cgroup
+	fmt.Fprintln(tw, "cgroup")
+	fmt.Fprintf(tw, "  detected\tv1=%t v2=%t\n", c.V1, c.V2)
+	if c.Version != 0 {
+		fmt.Fprintf(tw, "  version\t%d\n", c.Version)
+		fmt.Fprintf(tw, "  mount\t%s (root %s, %s)\n", c.Mount.MountPoint, c.Mount.Root, c.Mount.FilesystemType)
+		if len(c.Candidates) > 1 {
+			for _, m := range c.Candidates {
+				fmt.Fprintf(tw, "  candidate\t%s (root %s)\n", m.MountPoint, m.Root)
+			}
+		}
+		fmt.Fprintf(tw, "  cgroup line\t%s\n", c.CgroupLine)
+		fmt.Fprintf(tw, "  resolved path\t%s (%s)\n", c.ResolvedPath, c.Resolution)
+		for i, l := range c.Levels {
+			mark := ""
+			if i == c.Winner {
+				mark = " <- winner"
+			}
+			limit := "max"
+			if l.Limited {
+				limit = formatSize(l.Limit)
+				if l.File != "" {
+					limit += " (" + l.File + ")"
+				}
+			}
+			if l.SwapLimited {
+				limit += " + swap " + formatSize(l.Swap)
+			}
+			fmt.Fprintf(tw, "  level %d\t%s\t%s%s\n", i, l.Path, limit, mark)
+		}
+	}
+	if c.Error != "" {
+		fmt.Fprintf(tw, "  error\t%s\n", c.Error)
+	} else {
+		fmt.Fprintf(tw, "  limit\t%s\n", formatSize(c.Limit))
+		if c.SwapLimit > 0 {
+			fmt.Fprintf(tw, "  swap limit\t%s (counted in the limit)\n", formatSize(c.SwapLimit))
+		}
+	}
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "providers")
+	for _, p := range r.Providers {
+		if p.Error != "" {
+			fmt.Fprintf(tw, "  %s\terror: %s\n", p.Name, p.Error)
+			continue
+		}
+		fmt.Fprintf(tw, "  %s\t%s\n", p.Name, formatSize(p.Limit))
+	}
+
+	fmt.Fprintln(tw)
+	if r.GOMEMLIMIT == 0 {
+		fmt.Fprintf(tw, "GOMEMLIMIT\tnot set (no limit from %s)\n", r.Providers[0].Name)
+	} else {
+		fmt.Fprintf(tw, "GOMEMLIMIT\t%s (ratio %g of %s)\n", formatSize(r.GOMEMLIMIT), r.Ratio, formatSize(r.Limit))
+	}
+
+	return tw.Flush()
+}
+
+// formatSize formats the size in bytes with the largest binary unit that keeps it readable, e.g. "1073741824 (1GiB)".
+func formatSize(size uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	v, i := float64(size), 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 {
+		return strconv.FormatUint(size, 10) + "B"
+	}
+	num := strings.TrimRight(strings.TrimRight(strconv.FormatFloat(v, 'f', 2, 64), "0"), ".")
+	return fmt.Sprintf("%d (%s%s)", size, num, units[i])
+}
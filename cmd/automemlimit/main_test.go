@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		size uint64
+		want string
+	}{
+		{size: 0, want: "0B"},
+		{size: 1023, want: "1023B"},
+		{size: 1 << 30, want: "1073741824 (1GiB)"},
+		{size: 3 << 29, want: "1610612736 (1.5GiB)"},
+		{size: 6305947648, want: "6305947648 (5.87GiB)"},
+	}
+	for _, tt := range tests {
+		if got := formatSize(tt.size); got != tt.want {
+			t.Errorf("formatSize(%d) got = %v, want %v", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	var buf bytes.Buffer
+	if err := run(&buf, []string{"-json", "-ratio", "0.5"}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	var r report
+	if err := json.Unmarshal(buf.Bytes(), &r); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if r.Ratio != 0.5 {
+		t.Errorf("Ratio got = %v, want %v", r.Ratio, 0.5)
+	}
+	if len(r.Providers) != 4 {
+		t.Errorf("Providers got = %v, want 4 providers", r.Providers)
+	}
+	if r.GOMEMLIMIT != r.Limit/2 {
+		t.Errorf("GOMEMLIMIT got = %v, want %v", r.GOMEMLIMIT, r.Limit/2)
+	}
+
+	buf.Reset()
+	if err := run(&buf, nil); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "GOMEMLIMIT") {
+		t.Errorf("run() got = %q, want to contain GOMEMLIMIT", buf.String())
+	}
+
+	if err := run(&buf, []string{"-ratio", "2"}); err == nil {
+		t.Errorf("run() error = %v, wantErr %v", err, true)
+	}
+
+	// -swap counts the swap limit of the cgroup.
+	buf.Reset()
+	if err := run(&buf, []string{"-json", "-swap"}); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &r); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if r.Providers[0].Name != "FromCgroup (IncludeSwap)" {
+		t.Errorf("Providers[0].Name got = %v, want %v", r.Providers[0].Name, "FromCgroup (IncludeSwap)")
+	}
+
+	// -h prints the usage without an error.
+	buf.Reset()
+	if err := run(&buf, []string{"-h"}); err != nil {
+		t.Errorf("run() error = %v, wantErr %v", err, false)
+	}
+	if !strings.Contains(buf.String(), "-swap") {
+		t.Errorf("run() got = %q, want to contain the usage", buf.String())
+	}
+}
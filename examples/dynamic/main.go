@@ -1,12 +1,9 @@
 package main
 
 import (
-	"bytes"
-	"errors"
 	"log/slog"
 	"os"
 	"os/signal"
-	"strconv"
 	"time"
 
 	"github.com/KimMachineGun/automemlimit/memlimit"
@@ -17,7 +14,9 @@ func init() {
 
 	memlimit.SetGoMemLimitWithOpts(
 		memlimit.WithProvider(
-			FileProvider("limit.txt"),
+			memlimit.FromFile("limit.txt",
+				memlimit.WithMissingFile(memlimit.ApplyFallback(memlimit.FromCgroup, memlimit.FromSystem)),
+			),
 		),
 		memlimit.WithRefreshInterval(5*time.Second),
 		memlimit.WithLogger(slog.Default()),
@@ -31,22 +30,3 @@ func main() {
 	s := <-c
 	slog.Info("signal captured", slog.Any("signal", s))
 }
-
-func FileProvider(path string) memlimit.Provider {
-	return func() (uint64, error) {
-		b, err := os.ReadFile(path)
-		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				return memlimit.ApplyFallback(memlimit.FromCgroup, memlimit.FromSystem)()
-			}
-			return 0, err
-		}
-
-		b = bytes.TrimSpace(b)
-		if len(b) == 0 {
-			return 0, memlimit.ErrNoLimit
-		}
-
-		return strconv.ParseUint(string(b), 10, 64)
-	}
-}
@@ -0,0 +1,114 @@
+package memlimit
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/metrics"
+	"sync"
+)
+
+const (
+	metricTotalMemory    = "/memory/classes/total:bytes"
+	metricReleasedMemory = "/memory/classes/heap/released:bytes"
+
+	defaultAdaptiveSmoothing = 0.3
+)
+
+// AdaptiveHeadroom configures the adaptive headroom. See WithAdaptiveHeadroom.
+type AdaptiveHeadroom struct {
+	// Safety is the headroom in bytes kept in addition to the measured non-Go memory footprint.
+	Safety uint64
+	// Smoothing is the weight of the latest sample in the exponential moving average
+	// of the non-Go memory footprint, in the half-open range (0.0,1.0].
+	// The smaller it is, the slower GOMEMLIMIT follows the changes of the footprint.
+	//
+	// Default: 0.3
+	Smoothing float64
+}
+
+// applyAdaptiveHeadroom is a helper Provider function that subtracts the non-Go memory footprint
+// and the safety headroom from the given provider.
+// The footprint is sampled with the given function on every call,
+// and it is smoothed with the exponential moving average to avoid oscillation.
+func applyAdaptiveHeadroom(provider Provider, footprint func() (uint64, error), cfg AdaptiveHeadroom, logger *slog.Logger) Provider {
+	if cfg.Smoothing == 0 {
+		cfg.Smoothing = defaultAdaptiveSmoothing
+	}
+
+	var (
+		mu          sync.Mutex
+		avg         float64
+		initialized bool
+	)
+	return func() (uint64, error) {
+		if cfg.Smoothing < 0 || cfg.Smoothing > 1 {
+			return 0, fmt.Errorf("invalid smoothing: %f, smoothing should be in the range (0.0,1.0]", cfg.Smoothing)
+		}
+		limit, err := provider()
+		if err != nil {
+			return 0, err
+		}
+
+		sample, err := footprint()
+		if err != nil {
+			return 0, fmt.Errorf("failed to measure non-Go memory footprint: %w", err)
+		}
+
+		mu.Lock()
+		if !initialized {
+			avg, initialized = float64(sample), true
+		} else {
+			avg = cfg.Smoothing*float64(sample) + (1-cfg.Smoothing)*avg
+		}
+		headroom := uint64(avg) + cfg.Safety
+		mu.Unlock()
+
+		logger.Debug("non-Go memory footprint is measured",
+			slog.Uint64("sample", sample),
+			slog.Uint64("headroom", headroom),
+		)
+		if limit <= headroom {
+			return 0, fmt.Errorf("memory limit %d is not greater than the adaptive headroom %d", limit, headroom)
+		}
+
+		return limit - headroom, nil
+	}
+}
+
+// nonGoFootprint returns the memory usage of the cgroup minus the memory mapped by the Go runtime,
+// which is the memory used by cgo libraries, mmap, off-heap buffers and so on.
+func nonGoFootprint() (uint64, error) {
+	used, err := memoryUsage(CgroupOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	goMapped := goMappedMemory()
+	if used < goMapped {
+		return 0, nil
+	}
+
+	return used - goMapped, nil
+}
+
+// goMappedMemory returns the memory mapped by the Go runtime and not released to the OS.
+func goMappedMemory() uint64 {
+	samples := []metrics.Sample{
+		{Name: metricTotalMemory},
+		{Name: metricReleasedMemory},
+	}
+	metrics.Read(samples)
+
+	var total, released uint64
+	if samples[0].Value.Kind() == metrics.KindUint64 {
+		total = samples[0].Value.Uint64()
+	}
+	if samples[1].Value.Kind() == metrics.KindUint64 {
+		released = samples[1].Value.Uint64()
+	}
+	if released > total {
+		return 0
+	}
+
+	return total - released
+}
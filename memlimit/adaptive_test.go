@@ -0,0 +1,55 @@
+package memlimit
+
+import (
+	"fmt"
+	"log/slog"
+	"testing"
+)
+
+func TestApplyAdaptiveHeadroom(t *testing.T) {
+	var sample uint64
+	provider := applyAdaptiveHeadroom(
+		Limit(1000),
+		func() (uint64, error) {
+			return sample, nil
+		},
+		AdaptiveHeadroom{Safety: 100, Smoothing: 0.5},
+		slog.New(noopLogger{}),
+	)
+
+	tests := []struct {
+		sample uint64
+		want   uint64
+	}{
+		// the first sample is used as is.
+		{sample: 200, want: 700},
+		// 0.5*400 + 0.5*200 = 300
+		{sample: 400, want: 600},
+		// 0.5*100 + 0.5*300 = 200
+		{sample: 100, want: 700},
+	}
+	for i, tt := range tests {
+		sample = tt.sample
+		got, err := provider()
+		if err != nil {
+			t.Fatalf("[%d] applyAdaptiveHeadroom() error = %v", i, err)
+		}
+		if got != tt.want {
+			t.Errorf("[%d] applyAdaptiveHeadroom() got = %v, want %v", i, got, tt.want)
+		}
+	}
+
+	// headroom larger than the limit
+	sample = 10000
+	_, err := provider()
+	wantErr := fmt.Errorf("memory limit 1000 is not greater than the adaptive headroom 5200")
+	if err == nil || err.Error() != wantErr.Error() {
+		t.Errorf("applyAdaptiveHeadroom() error = %v, wantErr %v", err, wantErr)
+	}
+}
+
+func TestGoMappedMemory(t *testing.T) {
+	if got := goMappedMemory(); got == 0 {
+		t.Errorf("goMappedMemory() got = %v, want > 0", got)
+	}
+}
@@ -5,12 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
 	"math"
 	"os"
+	"path"
 	"path/filepath"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 var (
@@ -20,46 +24,316 @@ var (
 	ErrCgroupsNotSupported = errors.New("cgroups is not supported on this system")
 )
 
+// MemoryLimitSource selects which cgroup v2 interface files are used as the memory limit.
+// It has no effect on cgroup v1.
+type MemoryLimitSource int
+
+const (
+	// LimitSourceMax uses memory.max, the hard limit that triggers the OOM killer.
+	LimitSourceMax MemoryLimitSource = iota
+	// LimitSourceHigh uses memory.high, the throttling limit (e.g. systemd MemoryHigh=).
+	LimitSourceHigh
+	// LimitSourceMinOfMaxHigh uses the smaller of memory.max and memory.high.
+	LimitSourceMinOfMaxHigh
+)
+
+// files returns the cgroup v2 interface files for the source.
+func (s MemoryLimitSource) files() ([]string, error) {
+	switch s {
+	case LimitSourceMax:
+		return []string{"memory.max"}, nil
+	case LimitSourceHigh:
+		return []string{"memory.high"}, nil
+	case LimitSourceMinOfMaxHigh:
+		return []string{"memory.max", "memory.high"}, nil
+	default:
+		return nil, fmt.Errorf("unknown memory limit source: %d", s)
+	}
+}
+
+// CgroupOptions configures how the memory limit is retrieved from the cgroup. See NewCgroupProvider.
+type CgroupOptions struct {
+	// FS is the filesystem that the mount points in the mountinfo are resolved in.
+	// It is useful for reading the cgroup of another root, e.g. a hostPath-mounted /host or a fake tree in tests.
+	//
+	// Default: os.DirFS("/")
+	FS fs.FS
+	// ProcFS is the filesystem that contains the mountinfo and cgroup files of the process.
+	//
+	// Default: os.DirFS("/proc/self")
+	ProcFS fs.FS
+	// LimitSource selects which cgroup v2 interface files are used as the memory limit.
+	//
+	// Default: LimitSourceMax
+	LimitSource MemoryLimitSource
+	// PID is the process ID looked up in cgroup.procs when the cgroup path reported by ProcFS
+	// cannot be resolved under the root of the cgroup mount, e.g. in nested containers.
+	//
+	// Default: os.Getpid()
+	PID int
+	// IncludeSwap adds the swap limit to the memory limit, so that GOMEMLIMIT is set against the combined budget.
+	// For cgroup v2, memory.swap.max is added to the memory limit, and the swap is not counted if it is not limited.
+	// For cgroup v1, memory.memsw.limit_in_bytes is used if the swap accounting is enabled.
+	// The swap limit is reported separately by CgroupInfo.SwapLimit.
+	//
+	// Default: false
+	IncludeSwap bool
+	// Logger is used to report how the cgroup directory was located, e.g. which mount was selected.
+	//
+	// Default: slog.New(noopLogger{})
+	Logger *slog.Logger
+
+	// cache caches the located cgroup directories. It is nil if they are located on every read.
+	cache *cgroupCache
+}
+
+// systemCgroupCache is the cache shared by the options reading the default filesystems.
+var systemCgroupCache cgroupCache
+
+// withDefaults returns the options with the default values for the unset fields.
+func (o CgroupOptions) withDefaults() CgroupOptions {
+	if o.cache == nil && o.FS == nil && o.ProcFS == nil {
+		o.cache = &systemCgroupCache
+	}
+	if o.FS == nil {
+		o.FS = os.DirFS("/")
+	}
+	if o.ProcFS == nil {
+		o.ProcFS = os.DirFS("/proc/self")
+	}
+	if o.PID == 0 {
+		o.PID = os.Getpid()
+	}
+	if o.Logger == nil {
+		o.Logger = slog.New(noopLogger{})
+	}
+	return o
+}
+
+// readFile reads the file of the given absolute path from o.FS.
+func (o CgroupOptions) readFile(name string) ([]byte, error) {
+	return fs.ReadFile(o.FS, fsPath(name))
+}
+
+// open opens the file of the given absolute path from o.FS.
+func (o CgroupOptions) open(name string) (fs.File, error) {
+	return o.FS.Open(fsPath(name))
+}
+
+// fsPath converts the absolute path to the path for fs.FS.
+func fsPath(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// NewCgroupProvider returns a Provider that retrieves the memory limit from the cgroup with the given options.
+// FromCgroup is equivalent to NewCgroupProvider(CgroupOptions{}).
+//
+// The cgroup directory located with a fallback strategy (see ResolutionMountPoint and ResolutionSearch) is cached,
+// and it is located again only if the process moves to another cgroup or the directory is no longer accessible.
+func NewCgroupProvider(opts CgroupOptions) Provider {
+	opts = opts.withDefaults()
+	if opts.cache == nil {
+		opts.cache = &cgroupCache{}
+	}
+	return func() (uint64, error) {
+		return fromCgroup(opts, detectCgroupVersion)
+	}
+}
+
+// InspectCgroupWithOptions retrieves the memory limit from the cgroup with the given options
+// in the same way as NewCgroupProvider, and reports how it was discovered.
+// The returned CgroupInfo is never nil, and it is filled as much as possible even if an error is returned.
+func InspectCgroupWithOptions(opts CgroupOptions) (*CgroupInfo, error) {
+	return inspectCgroup(opts, detectCgroupVersion)
+}
+
 // fromCgroup retrieves the memory limit from the cgroup.
 // The versionDetector function is used to detect the cgroup version from the mountinfo.
-func fromCgroup(versionDetector func(mis []mountInfo) (bool, bool)) (uint64, error) {
-	mf, err := os.Open("/proc/self/mountinfo")
+func fromCgroup(opts CgroupOptions, versionDetector func(mis []mountInfo) (bool, bool)) (uint64, error) {
+	info, err := inspectCgroup(opts, versionDetector)
 	if err != nil {
-		return 0, fmt.Errorf("failed to open /proc/self/mountinfo: %w", err)
+		return 0, err
 	}
-	defer mf.Close()
+	return info.Limit, nil
+}
 
-	mis, err := parseMountInfo(mf)
+// inspectCgroup retrieves the memory limit from the cgroup and records how it was discovered.
+// The returned CgroupInfo is never nil, and it is filled as much as possible even if an error is returned.
+func inspectCgroup(opts CgroupOptions, versionDetector func(mis []mountInfo) (bool, bool)) (*CgroupInfo, error) {
+	info := &CgroupInfo{Winner: -1}
+	opts = opts.withDefaults()
+
+	files, err := opts.LimitSource.files()
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse mountinfo: %w", err)
+		return info, err
 	}
 
-	v1, v2 := versionDetector(mis)
-	if !(v1 || v2) {
-		return 0, ErrNoCgroup
+	mis, chs, err := readCgroupProcFiles(opts)
+	if err != nil {
+		return info, err
+	}
+
+	info.V1, info.V2 = versionDetector(mis)
+	if !(info.V1 || info.V2) {
+		return info, ErrNoCgroup
+	}
+
+	if info.V2 {
+		err := inspectCgroupV2(opts, info, chs, mis, files)
+		if err == nil || !info.V1 {
+			return info, err
+		}
 	}
 
-	cf, err := os.Open("/proc/self/cgroup")
+	return info, inspectCgroupV1(opts, info, chs, mis)
+}
+
+// memoryLimitFiles returns the files that hold the memory limit of the cgroup.
+// For cgroup v2, it returns the memory.max, memory.high and memory.swap.max files of the cgroup
+// and its ancestors up to the mountpoint.
+// For cgroup v1, it returns the memory.limit_in_bytes and memory.memsw.limit_in_bytes files of the cgroup.
+// Some of the files may not exist, e.g. if the swap accounting is disabled.
+func memoryLimitFiles(opts CgroupOptions) ([]string, error) {
+	opts = opts.withDefaults()
+	mis, chs, err := readCgroupProcFiles(opts)
 	if err != nil {
-		return 0, fmt.Errorf("failed to open /proc/self/cgroup: %w", err)
+		return nil, err
 	}
-	defer cf.Close()
 
-	chs, err := parseCgroupFile(cf)
+	v1, v2 := detectCgroupVersion(mis)
+	if !(v1 || v2) {
+		return nil, ErrNoCgroup
+	}
+
+	var files []string
+	if v2 {
+		loc, err := locateCgroupV2(opts, chs, mis)
+		if err != nil && !v1 {
+			return nil, err
+		} else if err == nil {
+			for currentPath := loc.path; ; {
+				for _, file := range []string{"memory.max", "memory.high", "memory.swap.max"} {
+					files = append(files, filepath.Join(currentPath, file))
+				}
+				parent := filepath.Dir(currentPath)
+				if currentPath == loc.mount.MountPoint || parent == currentPath {
+					break
+				}
+				currentPath = parent
+			}
+		}
+	}
+	if v1 {
+		loc, err := locateCgroupV1(opts, chs, mis)
+		if err != nil && len(files) == 0 {
+			return nil, err
+		} else if err == nil {
+			files = append(files,
+				filepath.Join(loc.path, "memory.limit_in_bytes"),
+				filepath.Join(loc.path, "memory.memsw.limit_in_bytes"),
+			)
+		}
+	}
+
+	return files, nil
+}
+
+// memoryUsage returns the current memory usage of the cgroup without the inactive page cache,
+// which is memory.current minus inactive_file of memory.stat for cgroup v2,
+// and memory.usage_in_bytes minus total_inactive_file of memory.stat for cgroup v1.
+// The inactive page cache is excluded since the kernel reclaims it before hitting the limit.
+func memoryUsage(opts CgroupOptions) (uint64, error) {
+	opts = opts.withDefaults()
+	mis, chs, err := readCgroupProcFiles(opts)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse cgroup file: %w", err)
+		return 0, err
+	}
+
+	v1, v2 := detectCgroupVersion(mis)
+	if !(v1 || v2) {
+		return 0, ErrNoCgroup
 	}
 
 	if v2 {
-		limit, err := getMemoryLimitV2(chs, mis)
+		loc, err := locateCgroupV2(opts, chs, mis)
 		if err == nil {
-			return limit, nil
+			return readWorkingSet(opts, loc.path, "memory.current", "inactive_file")
 		} else if !v1 {
 			return 0, err
 		}
 	}
 
-	return getMemoryLimitV1(chs, mis)
+	loc, err := locateCgroupV1(opts, chs, mis)
+	if err != nil {
+		return 0, err
+	}
+	return readWorkingSet(opts, loc.path, "memory.usage_in_bytes", "total_inactive_file")
+}
+
+// readWorkingSet reads the usage file and subtracts the inactive page cache key of memory.stat from it.
+// If memory.stat does not exist, the usage is returned as is.
+// this function expects the path to be the cgroup directory.
+func readWorkingSet(opts CgroupOptions, cgroupPath, usageFile, inactiveKey string) (uint64, error) {
+	usage, err := readUintFromFile(opts, filepath.Join(cgroupPath, usageFile))
+	if err != nil {
+		return 0, err
+	}
+
+	inactive, err := readMemoryStat(opts, filepath.Join(cgroupPath, "memory.stat"), inactiveKey)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, fmt.Errorf("failed to read %s: %w", inactiveKey, err)
+	}
+	if usage < inactive {
+		return 0, nil
+	}
+
+	return usage - inactive, nil
+}
+
+// readUintFromFile reads an unsigned integer from the given file.
+func readUintFromFile(opts CgroupOptions, path string) (uint64, error) {
+	b, err := opts.readFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+	}
+
+	v, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s value: %w", filepath.Base(path), err)
+	}
+
+	return v, nil
+}
+
+// readCgroupProcFiles reads and parses the mountinfo and cgroup files from opts.ProcFS.
+func readCgroupProcFiles(opts CgroupOptions) ([]mountInfo, []cgroupHierarchy, error) {
+	mf, err := opts.ProcFS.Open("mountinfo")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open mountinfo: %w", err)
+	}
+	defer mf.Close()
+
+	mis, err := parseMountInfo(mf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse mountinfo: %w", err)
+	}
+
+	cf, err := opts.ProcFS.Open("cgroup")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open cgroup: %w", err)
+	}
+	defer cf.Close()
+
+	chs, err := parseCgroupFile(cf)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse cgroup file: %w", err)
+	}
+
+	return mis, chs, nil
 }
 
 // detectCgroupVersion detects the cgroup version from the mountinfo.
@@ -76,46 +350,193 @@ func detectCgroupVersion(mis []mountInfo) (bool, bool) {
 	return v1, v2
 }
 
-// getMemoryLimitV2 retrieves the memory limit from the cgroup v2 controller.
-func getMemoryLimitV2(chs []cgroupHierarchy, mis []mountInfo) (uint64, error) {
+// cgroupLocation is the location of the cgroup that the process belongs to.
+type cgroupLocation struct {
+	// hierarchy is the entry of /proc/self/cgroup for the cgroup.
+	hierarchy cgroupHierarchy
+	// mount is the entry of /proc/self/mountinfo for the cgroup filesystem.
+	mount mountInfo
+	// path is the resolved path of the cgroup directory.
+	path string
+	// resolution is the strategy used to resolve the path. See ResolutionRoot.
+	resolution string
+	// candidates are the entries of /proc/self/mountinfo considered to locate the cgroup directory.
+	candidates []mountInfo
+}
+
+// cgroupCache caches the cgroup directories located with a fallback strategy of resolveCgroupPathWithFallback,
+// since they can involve a search of the whole cgroup filesystem.
+// The directories resolved under the root of the mount are not cached, since resolving them does not read any file.
+type cgroupCache struct {
+	mu   sync.Mutex
+	locs map[cgroupHierarchy]cgroupLocation
+}
+
+// selectCgroupMount is selectCgroupMount with the cache.
+// The cached location is used if the cgroup entry and the candidates are unchanged, and the directory is still accessible.
+// A nil cache selects the mount on every call.
+func (c *cgroupCache) selectCgroupMount(opts CgroupOptions, ch cgroupHierarchy, candidates []mountInfo) (cgroupLocation, error) {
+	if c == nil {
+		return selectCgroupMount(opts, ch, candidates)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if loc, ok := c.locs[ch]; ok && slices.Equal(loc.candidates, candidates) {
+		if _, err := fs.Stat(opts.FS, fsPath(loc.path)); err == nil {
+			return loc, nil
+		}
+		opts.Logger.Debug("cached cgroup directory is not accessible, locating it again",
+			slog.String("path", loc.path),
+			slog.String("cgroup_path", ch.CgroupPath),
+		)
+		delete(c.locs, ch)
+	}
+
+	loc, err := selectCgroupMount(opts, ch, candidates)
+	if err != nil {
+		return cgroupLocation{}, err
+	}
+	if loc.resolution != ResolutionRoot {
+		if c.locs == nil {
+			c.locs = make(map[cgroupHierarchy]cgroupLocation)
+		}
+		c.locs[ch] = loc
+	}
+
+	return loc, nil
+}
+
+// selectCgroupMount selects the cgroup mount to locate the cgroup directory from the candidates.
+// The mount whose root contains the cgroup path is preferred, and the deepest root wins if there are several.
+// If no root contains the cgroup path, the candidates are tried in order with the fallback strategies
+// of resolveCgroupPathWithFallback.
+func selectCgroupMount(opts CgroupOptions, ch cgroupHierarchy, candidates []mountInfo) (cgroupLocation, error) {
+	loc := cgroupLocation{hierarchy: ch, candidates: candidates}
+
+	best := -1
+	for i, mi := range candidates {
+		if _, err := resolveCgroupPath(mi.MountPoint, mi.Root, ch.CgroupPath); err != nil {
+			continue
+		}
+		if best == -1 || len(mi.Root) > len(candidates[best].Root) {
+			best = i
+		}
+	}
+
+	var firstErr error
+	if best != -1 {
+		loc.mount = candidates[best]
+		loc.path, loc.resolution, firstErr = resolveCgroupPathWithFallback(opts, loc.mount.MountPoint, loc.mount.Root, ch.CgroupPath)
+	} else {
+		for _, mi := range candidates {
+			cgroupPath, resolution, err := resolveCgroupPathWithFallback(opts, mi.MountPoint, mi.Root, ch.CgroupPath)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			loc.mount, loc.path, loc.resolution, firstErr = mi, cgroupPath, resolution, nil
+			break
+		}
+	}
+	if firstErr != nil {
+		return cgroupLocation{}, firstErr
+	}
+
+	if len(candidates) > 1 {
+		mountPoints := make([]string, len(candidates))
+		for i, mi := range candidates {
+			mountPoints[i] = mi.MountPoint
+		}
+		opts.Logger.Debug("cgroup mount selected",
+			slog.String("mountpoint", loc.mount.MountPoint),
+			slog.String("root", loc.mount.Root),
+			slog.String("cgroup_path", ch.CgroupPath),
+			slog.String("resolution", loc.resolution),
+			slog.Any("candidates", mountPoints),
+		)
+	}
+
+	return loc, nil
+}
+
+// inspectCgroupV2 retrieves the memory limit from the cgroup v2 controller.
+// The files are the interface files to read the memory limit from, e.g. memory.max.
+func inspectCgroupV2(opts CgroupOptions, info *CgroupInfo, chs []cgroupHierarchy, mis []mountInfo, files []string) error {
+	loc, err := locateCgroupV2(opts, chs, mis)
+	if err != nil {
+		return err
+	}
+	info.setLocation(2, loc)
+
+	// retrieve the memory limit from the memory.max (and/or memory.high) recursively.
+	info.Levels, info.Winner, err = walkCgroupV2Hierarchy(opts, loc.path, loc.mount.MountPoint, files...)
+	if err != nil {
+		return err
+	}
+	info.Limit = info.Levels[info.Winner].Limit
+
+	if opts.IncludeSwap {
+		// the effective swap limit is the most restrictive memory.swap.max in the hierarchy.
+		swapLimited := false
+		for i := range info.Levels {
+			level := &info.Levels[i]
+			swap, err := readMemoryLimitV2FromPath(opts, filepath.Join(level.Path, "memory.swap.max"))
+			if err != nil && !errors.Is(err, ErrNoLimit) {
+				return err
+			} else if err == nil {
+				level.Swap, level.SwapLimited = swap, true
+				if !swapLimited || swap < info.SwapLimit {
+					info.SwapLimit, swapLimited = swap, true
+				}
+			}
+		}
+		info.Limit += info.SwapLimit
+	}
+
+	return nil
+}
+
+// locateCgroupV2 locates the cgroup v2 directory of the process.
+func locateCgroupV2(opts CgroupOptions, chs []cgroupHierarchy, mis []mountInfo) (cgroupLocation, error) {
 	// find the cgroup v2 path for the memory controller.
 	// in cgroup v2, the paths are unified and the controller list is empty.
 	idx := slices.IndexFunc(chs, func(ch cgroupHierarchy) bool {
 		return ch.HierarchyID == "0" && ch.ControllerList == ""
 	})
 	if idx == -1 {
-		return 0, errors.New("cgroup v2 path not found")
+		return cgroupLocation{}, errors.New("cgroup v2 path not found")
 	}
-	relPath := chs[idx].CgroupPath
+	ch := chs[idx]
 
-	// find the mountpoint for the cgroup v2 controller.
-	idx = slices.IndexFunc(mis, func(mi mountInfo) bool {
-		return mi.FilesystemType == "cgroup2"
-	})
-	if idx == -1 {
-		return 0, errors.New("cgroup v2 mountpoint not found")
+	// find the mountpoints for the cgroup v2 controller.
+	// there can be several, e.g. a bind-mounted host cgroupfs.
+	var candidates []mountInfo
+	for _, mi := range mis {
+		if mi.FilesystemType == "cgroup2" {
+			candidates = append(candidates, mi)
+		}
 	}
-	root, mountPoint := mis[idx].Root, mis[idx].MountPoint
-
-	// resolve the actual cgroup path
-	cgroupPath, err := resolveCgroupPath(mountPoint, root, relPath)
-	if err != nil {
-		return 0, err
+	if len(candidates) == 0 {
+		return cgroupLocation{}, errors.New("cgroup v2 mountpoint not found")
 	}
 
-	// retrieve the memory limit from the memory.max recursively.
-	return walkCgroupV2Hierarchy(cgroupPath, mountPoint)
+	return opts.cache.selectCgroupMount(opts, ch, candidates)
 }
 
 // readMemoryLimitV2FromPath reads the memory limit for cgroup v2 from the given path.
-// this function expects the path to be memory.max file.
-func readMemoryLimitV2FromPath(path string) (uint64, error) {
-	b, err := os.ReadFile(path)
+// this function expects the path to be memory.max or memory.high file.
+func readMemoryLimitV2FromPath(opts CgroupOptions, path string) (uint64, error) {
+	name := filepath.Base(path)
+	b, err := opts.readFile(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return 0, ErrNoLimit
 		}
-		return 0, fmt.Errorf("failed to read memory.max: %w", err)
+		return 0, fmt.Errorf("failed to read %s: %w", name, err)
 	}
 
 	slimit := strings.TrimSpace(string(b))
@@ -125,27 +546,40 @@ func readMemoryLimitV2FromPath(path string) (uint64, error) {
 
 	limit, err := strconv.ParseUint(slimit, 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse memory.max value: %w", err)
+		return 0, fmt.Errorf("failed to parse %s value: %w", name, err)
 	}
 
 	return limit, nil
 }
 
 // walkCgroupV2Hierarchy walks up the cgroup v2 hierarchy to find the most restrictive memory limit.
-func walkCgroupV2Hierarchy(cgroupPath, mountPoint string) (uint64, error) {
+// It reads the given interface files at each level, and memory.max is read if no file is given.
+// It returns every level walked, from the cgroup to the mountpoint, and the index of the most restrictive one.
+func walkCgroupV2Hierarchy(opts CgroupOptions, cgroupPath, mountPoint string, files ...string) ([]CgroupLevel, int, error) {
+	if len(files) == 0 {
+		files = []string{"memory.max"}
+	}
+
 	var (
-		found              = false
-		minLimit    uint64 = math.MaxUint64
-		currentPath        = cgroupPath
+		levels      []CgroupLevel
+		winner      = -1
+		currentPath = cgroupPath
 	)
 	for {
-		limit, err := readMemoryLimitV2FromPath(filepath.Join(currentPath, "memory.max"))
-		if err != nil && !errors.Is(err, ErrNoLimit) {
-			return 0, err
-		} else if err == nil {
-			found = true
-			minLimit = min(minLimit, limit)
+		level := CgroupLevel{Path: currentPath}
+		for _, file := range files {
+			limit, err := readMemoryLimitV2FromPath(opts, filepath.Join(currentPath, file))
+			if err != nil && !errors.Is(err, ErrNoLimit) {
+				return levels, -1, err
+			} else if err == nil && (!level.Limited || limit < level.Limit) {
+				level.Limit, level.Limited, level.File = limit, true, file
+			}
+		}
+
+		if level.Limited && (winner == -1 || level.Limit < levels[winner].Limit) {
+			winner = len(levels)
 		}
+		levels = append(levels, level)
 
 		if currentPath == mountPoint {
 			break
@@ -157,41 +591,69 @@ func walkCgroupV2Hierarchy(cgroupPath, mountPoint string) (uint64, error) {
 		}
 		currentPath = parent
 	}
-	if !found {
-		return 0, ErrNoLimit
+	if winner == -1 {
+		return levels, -1, ErrNoLimit
+	}
+
+	return levels, winner, nil
+}
+
+// inspectCgroupV1 retrieves the memory limit from the cgroup v1 controller.
+func inspectCgroupV1(opts CgroupOptions, info *CgroupInfo, chs []cgroupHierarchy, mis []mountInfo) error {
+	loc, err := locateCgroupV1(opts, chs, mis)
+	if err != nil {
+		return err
+	}
+	info.setLocation(1, loc)
+
+	// retrieve the memory limit from the memory.stat and memory.limit_in_bytes files.
+	limit, err := readMemoryLimitV1FromPath(opts, loc.path)
+	if err != nil && !errors.Is(err, ErrNoLimit) {
+		return err
 	}
+	info.Levels = []CgroupLevel{{Path: loc.path, Limit: limit, Limited: err == nil}}
+	if err != nil {
+		return err
+	}
+	info.Winner, info.Limit = 0, limit
 
-	return minLimit, nil
+	if opts.IncludeSwap {
+		// memory.memsw.limit_in_bytes is the limit of memory+swap, so it is never less than the memory limit.
+		memsw, err := readMemswLimitV1FromPath(opts, loc.path)
+		if err != nil && !errors.Is(err, ErrNoLimit) {
+			return err
+		} else if err == nil && memsw > limit {
+			info.Levels[0].Swap, info.Levels[0].SwapLimited = memsw-limit, true
+			info.SwapLimit, info.Limit = memsw-limit, memsw
+		}
+	}
+
+	return nil
 }
 
-// getMemoryLimitV1 retrieves the memory limit from the cgroup v1 controller.
-func getMemoryLimitV1(chs []cgroupHierarchy, mis []mountInfo) (uint64, error) {
+// locateCgroupV1 locates the cgroup v1 directory of the process for the memory controller.
+func locateCgroupV1(opts CgroupOptions, chs []cgroupHierarchy, mis []mountInfo) (cgroupLocation, error) {
 	// find the cgroup v1 path for the memory controller.
 	idx := slices.IndexFunc(chs, func(ch cgroupHierarchy) bool {
 		return slices.Contains(strings.Split(ch.ControllerList, ","), "memory")
 	})
 	if idx == -1 {
-		return 0, errors.New("cgroup v1 path for memory controller not found")
+		return cgroupLocation{}, errors.New("cgroup v1 path for memory controller not found")
 	}
-	relPath := chs[idx].CgroupPath
+	ch := chs[idx]
 
-	// find the mountpoint for the cgroup v1 controller.
-	idx = slices.IndexFunc(mis, func(mi mountInfo) bool {
-		return mi.FilesystemType == "cgroup" && slices.Contains(strings.Split(mi.SuperOptions, ","), "memory")
-	})
-	if idx == -1 {
-		return 0, errors.New("cgroup v1 mountpoint for memory controller not found")
+	// find the mountpoints for the cgroup v1 controller.
+	var candidates []mountInfo
+	for _, mi := range mis {
+		if mi.FilesystemType == "cgroup" && slices.Contains(strings.Split(mi.SuperOptions, ","), "memory") {
+			candidates = append(candidates, mi)
+		}
 	}
-	root, mountPoint := mis[idx].Root, mis[idx].MountPoint
-
-	// resolve the actual cgroup path
-	cgroupPath, err := resolveCgroupPath(mountPoint, root, relPath)
-	if err != nil {
-		return 0, err
+	if len(candidates) == 0 {
+		return cgroupLocation{}, errors.New("cgroup v1 mountpoint for memory controller not found")
 	}
 
-	// retrieve the memory limit from the memory.stat and memory.limit_in_bytes files.
-	return readMemoryLimitV1FromPath(cgroupPath)
+	return opts.cache.selectCgroupMount(opts, ch, candidates)
 }
 
 // getCgroupV1NoLimit returns the maximum value that is used to represent no limit in cgroup v1.
@@ -203,29 +665,45 @@ func getCgroupV1NoLimit() uint64 {
 
 // readMemoryLimitV1FromPath reads the memory limit for cgroup v1 from the given path.
 // this function expects the path to be the cgroup directory.
-func readMemoryLimitV1FromPath(cgroupPath string) (uint64, error) {
-	// read hierarchical_memory_limit and memory.limit_in_bytes files.
-	// but if hierarchical_memory_limit is not available, then use the max value as a fallback.
-	hml, err := readHierarchicalMemoryLimit(filepath.Join(cgroupPath, "memory.stat"))
+func readMemoryLimitV1FromPath(opts CgroupOptions, cgroupPath string) (uint64, error) {
+	return readLimitV1FromPath(opts, cgroupPath, "memory.limit_in_bytes", "hierarchical_memory_limit")
+}
+
+// readMemswLimitV1FromPath reads the memory+swap limit for cgroup v1 from the given path.
+// this function expects the path to be the cgroup directory.
+// It returns ErrNoLimit if memory.memsw.limit_in_bytes does not exist, i.e. the swap accounting is disabled.
+func readMemswLimitV1FromPath(opts CgroupOptions, cgroupPath string) (uint64, error) {
+	if _, err := fs.Stat(opts.FS, fsPath(filepath.Join(cgroupPath, "memory.memsw.limit_in_bytes"))); errors.Is(err, fs.ErrNotExist) {
+		return 0, ErrNoLimit
+	}
+	return readLimitV1FromPath(opts, cgroupPath, "memory.memsw.limit_in_bytes", "hierarchical_memsw_limit")
+}
+
+// readLimitV1FromPath reads the limit for cgroup v1 from the given limit file and the hierarchical limit key of memory.stat.
+// this function expects the path to be the cgroup directory.
+func readLimitV1FromPath(opts CgroupOptions, cgroupPath, limitFile, hierarchicalKey string) (uint64, error) {
+	// read the hierarchical limit and the limit files.
+	// but if the hierarchical limit is not available, then use the max value as a fallback.
+	hml, err := readMemoryStat(opts, filepath.Join(cgroupPath, "memory.stat"), hierarchicalKey)
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		return 0, fmt.Errorf("failed to read hierarchical_memory_limit: %w", err)
+		return 0, fmt.Errorf("failed to read %s: %w", hierarchicalKey, err)
 	} else if hml == 0 {
 		hml = math.MaxUint64
 	}
 
-	// read memory.limit_in_bytes file.
-	b, err := os.ReadFile(filepath.Join(cgroupPath, "memory.limit_in_bytes"))
+	// read the limit file.
+	b, err := opts.readFile(filepath.Join(cgroupPath, limitFile))
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
-		return 0, fmt.Errorf("failed to read memory.limit_in_bytes: %w", err)
+		return 0, fmt.Errorf("failed to read %s: %w", limitFile, err)
 	}
 	lib, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse memory.limit_in_bytes value: %w", err)
+		return 0, fmt.Errorf("failed to parse %s value: %w", limitFile, err)
 	} else if lib == 0 {
 		hml = math.MaxUint64
 	}
 
-	// use the minimum value between hierarchical_memory_limit and memory.limit_in_bytes.
+	// use the minimum value between the hierarchical limit and the limit file.
 	// if the limit is the maximum value, then it is considered as no limit.
 	limit := min(hml, lib)
 	if limit >= getCgroupV1NoLimit() {
@@ -235,10 +713,11 @@ func readMemoryLimitV1FromPath(cgroupPath string) (uint64, error) {
 	return limit, nil
 }
 
-// readHierarchicalMemoryLimit extracts hierarchical_memory_limit from memory.stat.
+// readMemoryStat extracts the value of the given key (e.g. hierarchical_memory_limit) from memory.stat.
+// It returns 0 if the key is not found.
 // this function expects the path to be memory.stat file.
-func readHierarchicalMemoryLimit(path string) (uint64, error) {
-	file, err := os.Open(path)
+func readMemoryStat(opts CgroupOptions, path, key string) (uint64, error) {
+	file, err := opts.open(path)
 	if err != nil {
 		return 0, err
 	}
@@ -253,9 +732,9 @@ func readHierarchicalMemoryLimit(path string) (uint64, error) {
 			return 0, fmt.Errorf("failed to parse memory.stat %q: not enough fields", line)
 		}
 
-		if fields[0] == "hierarchical_memory_limit" {
+		if fields[0] == key {
 			if len(fields) > 2 {
-				return 0, fmt.Errorf("failed to parse memory.stat %q: too many fields for hierarchical_memory_limit", line)
+				return 0, fmt.Errorf("failed to parse memory.stat %q: too many fields for %s", line, key)
 			}
 			return strconv.ParseUint(fields[1], 10, 64)
 		}
@@ -420,6 +899,81 @@ func parseCgroupFile(r io.Reader) ([]cgroupHierarchy, error) {
 	return chs, nil
 }
 
+// Strategies to resolve the cgroup directory reported by CgroupInfo.Resolution.
+const (
+	// ResolutionRoot resolves the cgroup path from /proc/self/cgroup relative to the root of the cgroup mount.
+	ResolutionRoot = "root"
+	// ResolutionMountPoint uses the mountpoint itself, since the cgroup.procs of it contains the process.
+	// It happens when the cgroup mount is the root of a cgroup namespace, e.g. Docker-in-Docker.
+	ResolutionMountPoint = "mountpoint"
+	// ResolutionSearch uses the directory under the mountpoint whose cgroup.procs contains the process.
+	ResolutionSearch = "search"
+)
+
+// maxCgroupSearchDirs is the maximum number of directories visited by searchCgroupPath.
+const maxCgroupSearchDirs = 10000
+
+// resolveCgroupPathWithFallback resolves the actual cgroup path in the same way as resolveCgroupPath.
+// If the cgroupRelPath is not under the root, which happens with nested containers and cgroup namespaces,
+// it falls back to the mountpoint itself and then searches the directory under the mountpoint,
+// looking for opts.PID in cgroup.procs. It also returns the strategy that succeeded.
+func resolveCgroupPathWithFallback(opts CgroupOptions, mountpoint, root, cgroupRelPath string) (string, string, error) {
+	cgroupPath, err := resolveCgroupPath(mountpoint, root, cgroupRelPath)
+	if err == nil {
+		return cgroupPath, ResolutionRoot, nil
+	}
+
+	if cgroupContainsPID(opts, mountpoint) {
+		return mountpoint, ResolutionMountPoint, nil
+	}
+	if cgroupPath, ok := searchCgroupPath(opts, mountpoint); ok {
+		return cgroupPath, ResolutionSearch, nil
+	}
+
+	return "", "", err
+}
+
+// searchCgroupPath walks the directories under the mountpoint, and returns the first one whose cgroup.procs contains opts.PID.
+func searchCgroupPath(opts CgroupOptions, mountpoint string) (string, bool) {
+	var (
+		found   string
+		visited int
+	)
+	_ = fs.WalkDir(opts.FS, fsPath(mountpoint), func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			// skip unreadable directories and files.
+			return nil
+		}
+		if visited++; visited > maxCgroupSearchDirs {
+			return fs.SkipAll
+		}
+		if cgroupContainsPID(opts, "/"+p) {
+			found = path.Clean("/" + p)
+			return fs.SkipAll
+		}
+		return nil
+	})
+	return found, found != ""
+}
+
+// cgroupContainsPID reports whether the cgroup.procs of the given cgroup directory contains opts.PID.
+func cgroupContainsPID(opts CgroupOptions, cgroupPath string) bool {
+	f, err := opts.open(filepath.Join(cgroupPath, "cgroup.procs"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	pid := strconv.Itoa(opts.PID)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if strings.TrimSpace(s.Text()) == pid {
+			return true
+		}
+	}
+	return false
+}
+
 // resolveCgroupPath resolves the actual cgroup path from the mountpoint, root, and cgroupRelPath.
 func resolveCgroupPath(mountpoint, root, cgroupRelPath string) (string, error) {
 	rel, err := filepath.Rel(root, cgroupRelPath)
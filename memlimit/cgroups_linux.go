@@ -3,15 +3,51 @@
 
 package memlimit
 
+import (
+	"log/slog"
+	"os"
+	"strconv"
+)
+
 // FromCgroup retrieves the memory limit from the cgroup.
 func FromCgroup() (uint64, error) {
-	return fromCgroup(detectCgroupVersion)
+	return NewCgroupProvider(CgroupOptions{})()
+}
+
+// fromCgroupWithLogger returns a Provider equivalent to FromCgroup that reports how the cgroup directory
+// was located to the given logger.
+func fromCgroupWithLogger(logger *slog.Logger) Provider {
+	return NewCgroupProvider(CgroupOptions{Logger: logger})
+}
+
+// FromCgroupWithLimitSource returns a Provider that retrieves the memory limit from the cgroup
+// in the same way as FromCgroup, but reads the cgroup v2 interface files selected by the source.
+// For example, LimitSourceMinOfMaxHigh takes the minimum of memory.max and memory.high across the hierarchy.
+func FromCgroupWithLimitSource(source MemoryLimitSource) Provider {
+	return NewCgroupProvider(CgroupOptions{LimitSource: source})
+}
+
+// FromCgroupOfPID returns a Provider that retrieves the memory limit from the cgroup of the process with the given PID.
+// It reads /proc/<pid>/mountinfo and /proc/<pid>/cgroup, and resolves the mount points
+// relative to the root directory of the process (/proc/<pid>/root), so that it works across mount namespaces.
+// Reading another process's root directory may require privileges such as CAP_SYS_PTRACE.
+func FromCgroupOfPID(pid int) Provider {
+	return NewCgroupProvider(cgroupOptionsOfPID(pid))
+}
+
+func cgroupOptionsOfPID(pid int) CgroupOptions {
+	procDir := "/proc/" + strconv.Itoa(pid)
+	return CgroupOptions{
+		FS:     os.DirFS(procDir + "/root"),
+		ProcFS: os.DirFS(procDir),
+		PID:    pid,
+	}
 }
 
 // FromCgroupV1 retrieves the memory limit from the cgroup v1 controller.
 // After v1.0.0, this function could be removed and FromCgroup should be used instead.
 func FromCgroupV1() (uint64, error) {
-	return fromCgroup(func(_ []mountInfo) (bool, bool) {
+	return fromCgroup(CgroupOptions{}, func(_ []mountInfo) (bool, bool) {
 		return true, false
 	})
 }
@@ -26,7 +62,14 @@ func FromCgroupHybrid() (uint64, error) {
 // FromCgroupV2 retrieves the memory limit from the cgroup v2 controller.
 // After v1.0.0, this function could be removed and FromCgroup should be used instead.
 func FromCgroupV2() (uint64, error) {
-	return fromCgroup(func(_ []mountInfo) (bool, bool) {
+	return fromCgroup(CgroupOptions{}, func(_ []mountInfo) (bool, bool) {
 		return false, true
 	})
 }
+
+// InspectCgroup retrieves the memory limit from the cgroup in the same way as FromCgroup,
+// and reports how it was discovered.
+// The returned CgroupInfo is never nil, and it is filled as much as possible even if an error is returned.
+func InspectCgroup() (*CgroupInfo, error) {
+	return InspectCgroupWithOptions(CgroupOptions{})
+}
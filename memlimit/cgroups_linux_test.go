@@ -4,6 +4,7 @@
 package memlimit
 
 import (
+	"os"
 	"testing"
 )
 
@@ -68,3 +69,35 @@ func TestFromCgroupV2(t *testing.T) {
 		t.Fatalf("FromCgroupV2() got = %v, want %v", limit, expected)
 	}
 }
+
+func TestInspectCgroup(t *testing.T) {
+	if expected == 0 || cgVersion == 0 {
+		t.Skip()
+	}
+	info, err := InspectCgroup()
+	if err != nil {
+		t.Fatalf("InspectCgroup() error = %v, wantErr %v", err, nil)
+	}
+	if info.Version != int(cgVersion) {
+		t.Errorf("InspectCgroup() version = %v, want %v", info.Version, cgVersion)
+	}
+	if info.Limit != expected {
+		t.Errorf("InspectCgroup() limit = %v, want %v", info.Limit, expected)
+	}
+	if info.Winner < 0 || info.Winner >= len(info.Levels) || info.Levels[info.Winner].Limit != expected {
+		t.Errorf("InspectCgroup() winner = %v, levels = %+v", info.Winner, info.Levels)
+	}
+}
+
+func TestFromCgroupOfPID(t *testing.T) {
+	if expected == 0 || cgVersion == 0 {
+		t.Skip()
+	}
+	limit, err := FromCgroupOfPID(os.Getpid())()
+	if err != nil {
+		t.Fatalf("FromCgroupOfPID() error = %v, wantErr %v", err, nil)
+	}
+	if limit != expected {
+		t.Fatalf("FromCgroupOfPID() got = %v, want %v", limit, expected)
+	}
+}
@@ -1,8 +1,10 @@
 package memlimit
 
 import (
+	"io/fs"
 	"reflect"
 	"testing"
+	"testing/fstest"
 )
 
 func TestParseMountInfoLine(t *testing.T) {
@@ -253,3 +255,347 @@ func TestResolveCgroupPath(t *testing.T) {
 		})
 	}
 }
+
+func TestWalkCgroupV2Hierarchy(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sys/fs/cgroup/a/b/memory.max":  {Data: []byte("max\n")},
+		"sys/fs/cgroup/a/b/memory.high": {Data: []byte("524288\n")},
+		"sys/fs/cgroup/a/memory.max":    {Data: []byte("1048576\n")},
+	}
+	opts := CgroupOptions{FS: fsys}
+
+	levels, winner, err := walkCgroupV2Hierarchy(opts, "/sys/fs/cgroup/a/b", "/sys/fs/cgroup")
+	if err != nil {
+		t.Fatalf("walkCgroupV2Hierarchy() error = %v", err)
+	}
+	want := []CgroupLevel{
+		{Path: "/sys/fs/cgroup/a/b"},
+		{Path: "/sys/fs/cgroup/a", Limit: 1048576, Limited: true, File: "memory.max"},
+		{Path: "/sys/fs/cgroup"},
+	}
+	if !reflect.DeepEqual(levels, want) {
+		t.Errorf("walkCgroupV2Hierarchy() levels = %+v, want %+v", levels, want)
+	}
+	if winner != 1 {
+		t.Errorf("walkCgroupV2Hierarchy() winner = %v, want %v", winner, 1)
+	}
+
+	// min of memory.max and memory.high
+	levels, winner, err = walkCgroupV2Hierarchy(opts, "/sys/fs/cgroup/a/b", "/sys/fs/cgroup", "memory.max", "memory.high")
+	if err != nil {
+		t.Fatalf("walkCgroupV2Hierarchy() error = %v", err)
+	}
+	want[0] = CgroupLevel{Path: "/sys/fs/cgroup/a/b", Limit: 524288, Limited: true, File: "memory.high"}
+	if !reflect.DeepEqual(levels, want) {
+		t.Errorf("walkCgroupV2Hierarchy() levels = %+v, want %+v", levels, want)
+	}
+	if winner != 0 {
+		t.Errorf("walkCgroupV2Hierarchy() winner = %v, want %v", winner, 0)
+	}
+
+	fsys["sys/fs/cgroup/a/memory.max"] = &fstest.MapFile{Data: []byte("max\n")}
+	_, winner, err = walkCgroupV2Hierarchy(opts, "/sys/fs/cgroup/a/b", "/sys/fs/cgroup")
+	if err != ErrNoLimit {
+		t.Errorf("walkCgroupV2Hierarchy() error = %v, want %v", err, ErrNoLimit)
+	}
+	if winner != -1 {
+		t.Errorf("walkCgroupV2Hierarchy() winner = %v, want %v", winner, -1)
+	}
+}
+
+func TestNewCgroupProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		source  MemoryLimitSource
+		swap    bool
+		want    uint64
+		wantErr error
+	}{
+		{
+			name: "cgroup v2",
+			fsys: fstest.MapFS{
+				"proc/self/mountinfo":                    {Data: []byte("35 24 0:30 / /sys/fs/cgroup rw,nosuid,nodev,noexec,relatime shared:9 - cgroup2 cgroup2 rw\n")},
+				"proc/self/cgroup":                       {Data: []byte("0::/kubepods/pod1\n")},
+				"sys/fs/cgroup/kubepods/pod1/memory.max": {Data: []byte("1073741824\n")},
+				"sys/fs/cgroup/kubepods/memory.max":      {Data: []byte("2147483648\n")},
+			},
+			want: 1073741824,
+		},
+		{
+			name: "cgroup v2 memory.high",
+			fsys: fstest.MapFS{
+				"proc/self/mountinfo":                     {Data: []byte("35 24 0:30 / /sys/fs/cgroup rw,nosuid,nodev,noexec,relatime shared:9 - cgroup2 cgroup2 rw\n")},
+				"proc/self/cgroup":                        {Data: []byte("0::/kubepods/pod1\n")},
+				"sys/fs/cgroup/kubepods/pod1/memory.max":  {Data: []byte("1073741824\n")},
+				"sys/fs/cgroup/kubepods/pod1/memory.high": {Data: []byte("536870912\n")},
+			},
+			source: LimitSourceMinOfMaxHigh,
+			want:   536870912,
+		},
+		{
+			name: "cgroup v2 multiple mounts",
+			fsys: fstest.MapFS{
+				"proc/self/mountinfo": {Data: []byte("" +
+					"35 24 0:30 /system.slice /sys/fs/cgroup/unified rw - cgroup2 cgroup2 rw\n" +
+					"36 24 0:30 / /host/sys/fs/cgroup rw - cgroup2 cgroup2 rw\n" +
+					"37 24 0:30 /kubepods /sys/fs/cgroup rw - cgroup2 cgroup2 rw\n")},
+				"proc/self/cgroup":                            {Data: []byte("0::/kubepods/pod1\n")},
+				"sys/fs/cgroup/pod1/memory.max":               {Data: []byte("1073741824\n")},
+				"host/sys/fs/cgroup/kubepods/pod1/memory.max": {Data: []byte("2147483648\n")},
+			},
+			want: 1073741824,
+		},
+		{
+			name: "cgroup v1",
+			fsys: fstest.MapFS{
+				"proc/self/mountinfo": {Data: []byte("36 32 0:32 / /sys/fs/cgroup/memory rw,relatime - cgroup cgroup rw,memory\n")},
+				"proc/self/cgroup":    {Data: []byte("4:memory:/docker/abc\n")},
+				"sys/fs/cgroup/memory/docker/abc/memory.limit_in_bytes": {Data: []byte("1073741824\n")},
+				"sys/fs/cgroup/memory/docker/abc/memory.stat":           {Data: []byte("cache 0\nhierarchical_memory_limit 1073741824\n")},
+			},
+			want: 1073741824,
+		},
+		{
+			name: "cgroup v2 swap",
+			fsys: fstest.MapFS{
+				"proc/self/mountinfo":                         {Data: []byte("35 24 0:30 / /sys/fs/cgroup rw - cgroup2 cgroup2 rw\n")},
+				"proc/self/cgroup":                            {Data: []byte("0::/kubepods/pod1\n")},
+				"sys/fs/cgroup/kubepods/pod1/memory.max":      {Data: []byte("1073741824\n")},
+				"sys/fs/cgroup/kubepods/pod1/memory.swap.max": {Data: []byte("536870912\n")},
+				"sys/fs/cgroup/kubepods/memory.swap.max":      {Data: []byte("max\n")},
+			},
+			swap: true,
+			want: 1610612736,
+		},
+		{
+			name: "cgroup v1 swap",
+			fsys: fstest.MapFS{
+				"proc/self/mountinfo": {Data: []byte("36 32 0:32 / /sys/fs/cgroup/memory rw,relatime - cgroup cgroup rw,memory\n")},
+				"proc/self/cgroup":    {Data: []byte("4:memory:/docker/abc\n")},
+				"sys/fs/cgroup/memory/docker/abc/memory.limit_in_bytes":       {Data: []byte("1073741824\n")},
+				"sys/fs/cgroup/memory/docker/abc/memory.memsw.limit_in_bytes": {Data: []byte("1610612736\n")},
+				"sys/fs/cgroup/memory/docker/abc/memory.stat":                 {Data: []byte("cache 0\nhierarchical_memory_limit 1073741824\nhierarchical_memsw_limit 1610612736\n")},
+			},
+			swap: true,
+			want: 1610612736,
+		},
+		{
+			name: "cgroup v1 swap accounting disabled",
+			fsys: fstest.MapFS{
+				"proc/self/mountinfo": {Data: []byte("36 32 0:32 / /sys/fs/cgroup/memory rw,relatime - cgroup cgroup rw,memory\n")},
+				"proc/self/cgroup":    {Data: []byte("4:memory:/docker/abc\n")},
+				"sys/fs/cgroup/memory/docker/abc/memory.limit_in_bytes": {Data: []byte("1073741824\n")},
+			},
+			swap: true,
+			want: 1073741824,
+		},
+		{
+			name: "no cgroup",
+			fsys: fstest.MapFS{
+				"proc/self/mountinfo": {Data: []byte("25 1 0:22 / /dev rw - devtmpfs udev rw\n")},
+				"proc/self/cgroup":    {Data: []byte("")},
+			},
+			wantErr: ErrNoCgroup,
+		},
+		{
+			name: "no limit",
+			fsys: fstest.MapFS{
+				"proc/self/mountinfo":                    {Data: []byte("35 24 0:30 / /sys/fs/cgroup rw - cgroup2 cgroup2 rw\n")},
+				"proc/self/cgroup":                       {Data: []byte("0::/kubepods/pod1\n")},
+				"sys/fs/cgroup/kubepods/pod1/memory.max": {Data: []byte("max\n")},
+			},
+			wantErr: ErrNoLimit,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procFS, err := fs.Sub(tt.fsys, "proc/self")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := NewCgroupProvider(CgroupOptions{
+				FS:          tt.fsys,
+				ProcFS:      procFS,
+				LimitSource: tt.source,
+				IncludeSwap: tt.swap,
+			})()
+			if err != tt.wantErr {
+				t.Errorf("NewCgroupProvider() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("NewCgroupProvider() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewCgroupProvider_Cache(t *testing.T) {
+	fsys := fstest.MapFS{
+		"proc/self/mountinfo":                   {Data: []byte("35 24 0:30 /docker/abc /sys/fs/cgroup rw - cgroup2 cgroup2 rw\n")},
+		"proc/self/cgroup":                      {Data: []byte("0::/docker/def\n")},
+		"sys/fs/cgroup/cgroup.procs":            {Data: []byte("1\n")},
+		"sys/fs/cgroup/app/cgroup.procs":        {Data: []byte("42\n")},
+		"sys/fs/cgroup/app/memory.max":          {Data: []byte("1073741824\n")},
+		"sys/fs/cgroup/other/cgroup.procs":      {Data: []byte("")},
+		"sys/fs/cgroup/other/memory.max":        {Data: []byte("536870912\n")},
+		"sys/fs/cgroup/other/nested/memory.max": {Data: []byte("268435456\n")},
+	}
+	procFS, err := fs.Sub(fsys, "proc/self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := NewCgroupProvider(CgroupOptions{FS: fsys, ProcFS: procFS, PID: 42})
+
+	if got, err := provider(); err != nil || got != 1073741824 {
+		t.Fatalf("provider() got = %v, %v, want %v", got, err, 1073741824)
+	}
+
+	// the searched directory is cached, so it is used even if the process is no longer found in it.
+	fsys["sys/fs/cgroup/app/cgroup.procs"] = &fstest.MapFile{Data: []byte("")}
+	fsys["sys/fs/cgroup/other/cgroup.procs"] = &fstest.MapFile{Data: []byte("42\n")}
+	if got, err := provider(); err != nil || got != 1073741824 {
+		t.Fatalf("provider() got = %v, %v, want %v", got, err, 1073741824)
+	}
+
+	// the directory is located again once the cached one is gone.
+	delete(fsys, "sys/fs/cgroup/app/cgroup.procs")
+	delete(fsys, "sys/fs/cgroup/app/memory.max")
+	if got, err := provider(); err != nil || got != 536870912 {
+		t.Fatalf("provider() got = %v, %v, want %v", got, err, 536870912)
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		want    uint64
+		wantErr bool
+	}{
+		{
+			name: "cgroup v2",
+			fsys: fstest.MapFS{
+				"proc/self/mountinfo":                        {Data: []byte("35 24 0:30 / /sys/fs/cgroup rw - cgroup2 cgroup2 rw\n")},
+				"proc/self/cgroup":                           {Data: []byte("0::/kubepods/pod1\n")},
+				"sys/fs/cgroup/kubepods/pod1/memory.current": {Data: []byte("1073741824\n")},
+				"sys/fs/cgroup/kubepods/pod1/memory.stat":    {Data: []byte("anon 536870912\nfile 536870912\nactive_file 268435456\ninactive_file 268435456\n")},
+			},
+			want: 805306368,
+		},
+		{
+			name: "cgroup v1",
+			fsys: fstest.MapFS{
+				"proc/self/mountinfo": {Data: []byte("36 32 0:32 / /sys/fs/cgroup/memory rw,relatime - cgroup cgroup rw,memory\n")},
+				"proc/self/cgroup":    {Data: []byte("4:memory:/docker/abc\n")},
+				"sys/fs/cgroup/memory/docker/abc/memory.usage_in_bytes": {Data: []byte("1073741824\n")},
+				"sys/fs/cgroup/memory/docker/abc/memory.stat":           {Data: []byte("inactive_file 1\ntotal_inactive_file 268435456\n")},
+			},
+			want: 805306368,
+		},
+		{
+			name: "inactive file larger than usage",
+			fsys: fstest.MapFS{
+				"proc/self/mountinfo":                        {Data: []byte("35 24 0:30 / /sys/fs/cgroup rw - cgroup2 cgroup2 rw\n")},
+				"proc/self/cgroup":                           {Data: []byte("0::/kubepods/pod1\n")},
+				"sys/fs/cgroup/kubepods/pod1/memory.current": {Data: []byte("1024\n")},
+				"sys/fs/cgroup/kubepods/pod1/memory.stat":    {Data: []byte("inactive_file 4096\n")},
+			},
+			want: 0,
+		},
+		{
+			name: "no memory.stat",
+			fsys: fstest.MapFS{
+				"proc/self/mountinfo":                        {Data: []byte("35 24 0:30 / /sys/fs/cgroup rw - cgroup2 cgroup2 rw\n")},
+				"proc/self/cgroup":                           {Data: []byte("0::/kubepods/pod1\n")},
+				"sys/fs/cgroup/kubepods/pod1/memory.current": {Data: []byte("1073741824\n")},
+			},
+			want: 1073741824,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procFS, err := fs.Sub(tt.fsys, "proc/self")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := memoryUsage(CgroupOptions{FS: tt.fsys, ProcFS: procFS})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("memoryUsage() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("memoryUsage() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveCgroupPathWithFallback(t *testing.T) {
+	tests := []struct {
+		name           string
+		fsys           fstest.MapFS
+		root           string
+		cgroupRelPath  string
+		want           string
+		wantResolution string
+		wantErr        bool
+	}{
+		{
+			name:           "root",
+			fsys:           fstest.MapFS{},
+			root:           "/",
+			cgroupRelPath:  "/docker/abc",
+			want:           "/sys/fs/cgroup/docker/abc",
+			wantResolution: ResolutionRoot,
+		},
+		{
+			name: "mountpoint",
+			fsys: fstest.MapFS{
+				"sys/fs/cgroup/cgroup.procs": {Data: []byte("1\n42\n")},
+			},
+			root:           "/docker/abc",
+			cgroupRelPath:  "/docker/def",
+			want:           "/sys/fs/cgroup",
+			wantResolution: ResolutionMountPoint,
+		},
+		{
+			name: "search",
+			fsys: fstest.MapFS{
+				"sys/fs/cgroup/cgroup.procs":         {Data: []byte("1\n")},
+				"sys/fs/cgroup/init/cgroup.procs":    {Data: []byte("7\n")},
+				"sys/fs/cgroup/app/cgroup.procs":     {Data: []byte("")},
+				"sys/fs/cgroup/app/web/cgroup.procs": {Data: []byte("41\n42\n")},
+			},
+			root:           "/docker/abc",
+			cgroupRelPath:  "/docker/def",
+			want:           "/sys/fs/cgroup/app/web",
+			wantResolution: ResolutionSearch,
+		},
+		{
+			name: "not found",
+			fsys: fstest.MapFS{
+				"sys/fs/cgroup/cgroup.procs": {Data: []byte("1\n")},
+			},
+			root:          "/docker/abc",
+			cgroupRelPath: "/docker/def",
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, resolution, err := resolveCgroupPathWithFallback(CgroupOptions{FS: tt.fsys, PID: 42}, "/sys/fs/cgroup", tt.root, tt.cgroupRelPath)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("resolveCgroupPathWithFallback() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("resolveCgroupPathWithFallback() got = %v, want %v", got, tt.want)
+			}
+			if resolution != tt.wantResolution {
+				t.Errorf("resolveCgroupPathWithFallback() resolution = %v, want %v", resolution, tt.wantResolution)
+			}
+		})
+	}
+}
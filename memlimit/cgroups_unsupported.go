@@ -3,10 +3,26 @@
 
 package memlimit
 
+import (
+	"log/slog"
+)
+
 func FromCgroup() (uint64, error) {
 	return 0, ErrCgroupsNotSupported
 }
 
+func fromCgroupWithLogger(_ *slog.Logger) Provider {
+	return FromCgroup
+}
+
+func FromCgroupWithLimitSource(_ MemoryLimitSource) Provider {
+	return FromCgroup
+}
+
+func FromCgroupOfPID(_ int) Provider {
+	return FromCgroup
+}
+
 func FromCgroupV1() (uint64, error) {
 	return 0, ErrCgroupsNotSupported
 }
@@ -18,3 +34,7 @@ func FromCgroupHybrid() (uint64, error) {
 func FromCgroupV2() (uint64, error) {
 	return 0, ErrCgroupsNotSupported
 }
+
+func InspectCgroup() (*CgroupInfo, error) {
+	return &CgroupInfo{Winner: -1}, ErrCgroupsNotSupported
+}
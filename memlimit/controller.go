@@ -0,0 +1,405 @@
+package memlimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStopped is returned when refreshing a Controller that has been stopped.
+var ErrStopped = errors.New("controller is stopped")
+
+// Sources of the GOMEMLIMIT updates reported by Change.
+const (
+	// SourceInit is the initial update by SetGoMemLimitWithOpts or Start.
+	SourceInit = "init"
+	// SourceRefresh is the update by the refresh goroutine.
+	SourceRefresh = "refresh"
+	// SourceManual is the update by Controller.Refresh.
+	SourceManual = "manual"
+	// SourceWatch is the update triggered by a modification of the cgroup memory limit files.
+	SourceWatch = "watch"
+	// SourcePressure is the update triggered by the memory pressure monitor. See WithPressureTightening.
+	SourcePressure = "pressure"
+	// SourceMemoryEvents is the update triggered by the memory.events watchdog. See WithMemoryEventsWatchdog.
+	SourceMemoryEvents = "memory_events"
+)
+
+// Update describes an attempt to update GOMEMLIMIT made by automemlimit.
+// Unlike Change, it is reported whether or not GOMEMLIMIT has changed, and even if the attempt failed.
+type Update struct {
+	// Source is where the attempt came from. See SourceInit, SourceRefresh, SourceManual, SourceWatch, SourcePressure
+	// and SourceMemoryEvents.
+	Source string
+	// Raw is the memory limit returned by the provider before the ratio was applied.
+	// It is 0 if the provider returned an error including ErrNoLimit.
+	Raw uint64
+	// Limit is the GOMEMLIMIT after the attempt.
+	Limit uint64
+	// Previous is the GOMEMLIMIT before the attempt.
+	Previous uint64
+	// Ratio is the ratio of the memory limit to set as GOMEMLIMIT.
+	// It is 0 if the adaptive headroom is enabled, since the headroom is not determined by a ratio.
+	Ratio float64
+	// Err is the error occurred during the attempt, if any.
+	// In the initial attempt, it can be ErrNoLimit.
+	Err error
+	// Time is when the attempt was made.
+	Time time.Time
+	// DryRun reports whether the attempt was made in the dry run mode.
+	// If true, Limit and Previous are the GOMEMLIMIT that would have been set, and the runtime is not changed.
+	DryRun bool
+}
+
+// defaultWatchFallbackInterval is the refresh interval used when watching is not possible
+// and no refresh interval is configured.
+const defaultWatchFallbackInterval = time.Minute
+
+// Change describes an update of GOMEMLIMIT made by automemlimit.
+type Change struct {
+	// Old is the GOMEMLIMIT before the update.
+	Old uint64
+	// New is the GOMEMLIMIT after the update.
+	New uint64
+	// Raw is the memory limit returned by the provider before the ratio was applied.
+	// It is 0 if the provider returned ErrNoLimit.
+	Raw uint64
+	// Source is where the update came from. See SourceInit, SourceRefresh, SourceManual, SourceWatch, SourcePressure
+	// and SourceMemoryEvents.
+	Source string
+}
+
+// Controller controls the GOMEMLIMIT set by Start.
+// It is safe for concurrent use.
+type Controller struct {
+	logger      *slog.Logger
+	restore     bool
+	snapshot    int64
+	gcSnapshot  int
+	gc          *gcPercentSetter
+	dryRun      bool
+	onChange    []func(Change)
+	changeChans []chan<- Change
+	onUpdate    []func(Update)
+	ratio       float64
+
+	// mu serializes the updates of the Go's memory limit.
+	mu       sync.Mutex
+	provider Provider
+	stopped  bool
+	// dryLimit is the GOMEMLIMIT that would have been set in the dry run mode.
+	dryLimit uint64
+
+	// notifyMu serializes the callbacks and the channels in the order of the updates.
+	notifyMu sync.Mutex
+
+	limit atomic.Uint64
+	raw   atomic.Uint64
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// Start sets GOMEMLIMIT in the same way as SetGoMemLimitWithOpts and returns a Controller
+// that can be used to refresh the limit on demand and to stop the refresh goroutine.
+//
+// The refresh goroutine stops when the given context is done or Stop is called.
+// If WithRestoreOnStop is given, the memory limit that was set before Start is restored at that time.
+//
+// The returned Controller is never nil, even if an error is returned,
+// so that it can be stopped regardless of the result of the initial update.
+func Start(ctx context.Context, opts ...Option) (*Controller, error) {
+	return start(ctx, opts...)
+}
+
+func newController(ctx context.Context, cfg *config, snapshot int64, gcSnapshot int) *Controller {
+	ctx, cancel := context.WithCancel(ctx)
+	c := &Controller{
+		logger:      cfg.logger,
+		restore:     cfg.restore,
+		snapshot:    snapshot,
+		gcSnapshot:  gcSnapshot,
+		dryRun:      cfg.dryRun,
+		dryLimit:    uint64(snapshot),
+		onChange:    cfg.onChange,
+		changeChans: cfg.changeChans,
+		onUpdate:    cfg.onUpdate,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	if cfg.gcPercent != nil {
+		c.gc = newGCPercentSetter(*cfg.gcPercent, gcSnapshot)
+	}
+	context.AfterFunc(ctx, func() {
+		c.stopOnce.Do(c.teardown)
+	})
+	return c
+}
+
+// Limit returns the last GOMEMLIMIT applied by the Controller.
+// It returns 0 if no limit has been applied.
+func (c *Controller) Limit() uint64 {
+	return c.limit.Load()
+}
+
+// Refresh fetches the memory limit from the provider immediately and reapplies it if it has changed.
+// ErrNoLimit is treated as math.MaxInt64 as in the refresh goroutine.
+// If automemlimit is disabled (e.g. GOMEMLIMIT is already set), it does nothing and returns 0.
+func (c *Controller) Refresh() (uint64, error) {
+	return c.refresh(SourceManual)
+}
+
+// Stop stops the refresh goroutine and waits for it to exit.
+// If WithRestoreOnStop is given, it restores the memory limit that was set before Start.
+// It is safe to call Stop multiple times.
+func (c *Controller) Stop() {
+	c.cancel()
+	c.wg.Wait()
+	c.stopOnce.Do(c.teardown)
+}
+
+// run sets the provider and spawns a goroutine that runs every refresh duration
+// and updates the GOMEMLIMIT if it has changed.
+// It also updates the GOMEMLIMIT when the cgroup memory limit files are modified if watching is enabled,
+// when the memory pressure changes the tightening if pressure tightening is enabled,
+// and when the memory events cut starts or ends if the memory events watchdog is enabled.
+// See more details in the documentation of WithRefreshInterval, WithWatch, WithPressureTightening
+// and WithMemoryEventsWatchdog.
+func (c *Controller) run(provider Provider, cfg *config, factor *pressureFactor, cut *memoryEventsCut) {
+	c.mu.Lock()
+	c.provider = noErrNoLimitProvider(provider)
+	c.mu.Unlock()
+
+	refresh := cfg.refresh
+	var events <-chan struct{}
+	if cfg.watch {
+		var err error
+		events, err = watchMemoryLimit(c.ctx, &c.wg, cfg.limitFiles, c.logger)
+		if err != nil {
+			c.logger.Warn("failed to watch memory limit, falling back to polling", slog.Any("error", err))
+			if refresh == 0 {
+				refresh = defaultWatchFallbackInterval
+			}
+		}
+	}
+
+	var pressureEvents <-chan struct{}
+	if cfg.pressure != nil {
+		// the pressure file is resolved once, since locating the cgroup parses the mountinfo.
+		file := memoryPressureFile(CgroupOptions{})
+		pressureEvents = monitorMemoryPressure(c.ctx, &c.wg, file, *cfg.pressure, factor, cfg.psi, c.logger)
+	}
+
+	var memoryEvents <-chan struct{}
+	if cfg.memoryEvents != nil {
+		policy := *cfg.memoryEvents
+		if c.dryRun {
+			policy.FreeOSMemory = false
+		}
+		memoryEvents = watchMemoryEvents(c.ctx, &c.wg, policy, cut, newMemoryEventsReader(CgroupOptions{}), c.logger)
+	}
+
+	if refresh == 0 && events == nil && pressureEvents == nil && memoryEvents == nil {
+		return
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		var tick <-chan time.Time
+		if refresh > 0 {
+			t := time.NewTicker(refresh)
+			defer t.Stop()
+			tick = t.C
+		}
+		for {
+			var source string
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-tick:
+				source = SourceRefresh
+			case _, ok := <-events:
+				if !ok {
+					// watching failed, fall back to polling if there is no ticker.
+					events = nil
+					if tick == nil {
+						c.logger.Warn("memory limit watcher is closed, falling back to polling")
+						t := time.NewTicker(defaultWatchFallbackInterval)
+						defer t.Stop()
+						tick = t.C
+					}
+					continue
+				}
+				source = SourceWatch
+			case _, ok := <-pressureEvents:
+				if !ok {
+					pressureEvents = nil
+					continue
+				}
+				source = SourcePressure
+			case _, ok := <-memoryEvents:
+				if !ok {
+					memoryEvents = nil
+					continue
+				}
+				source = SourceMemoryEvents
+			}
+
+			_, err := c.refresh(source)
+			if errors.Is(err, ErrStopped) {
+				return
+			} else if err != nil {
+				c.logger.Error("failed to refresh GOMEMLIMIT", slog.Any("error", err))
+			}
+		}
+	}()
+}
+
+// refresh updates the Go's memory limit with the provider set by run.
+func (c *Controller) refresh(source string) (uint64, error) {
+	c.mu.Lock()
+	provider := c.provider
+	c.mu.Unlock()
+
+	if provider == nil {
+		if c.isStopped() {
+			return 0, ErrStopped
+		}
+		return 0, nil
+	}
+
+	return c.apply(provider, source)
+}
+
+// apply updates the Go's memory limit with the given provider and notifies the change if any.
+func (c *Controller) apply(provider Provider, source string) (uint64, error) {
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return 0, ErrStopped
+	}
+	prev, limit, raw, err := c.update(provider)
+	current := c.currentLimit()
+	// take notifyMu before releasing mu, so that the updates are reported in the order they were made.
+	c.notifyMu.Lock()
+	c.mu.Unlock()
+	defer c.notifyMu.Unlock()
+
+	now := time.Now()
+	for _, fn := range c.onUpdate {
+		fn(Update{
+			Source:   source,
+			Raw:      raw,
+			Limit:    current,
+			Previous: prev,
+			Ratio:    c.ratio,
+			Err:      err,
+			Time:     now,
+			DryRun:   c.dryRun,
+		})
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if limit != prev {
+		c.notify(Change{
+			Old:    prev,
+			New:    limit,
+			Raw:    raw,
+			Source: source,
+		})
+	}
+
+	return limit, nil
+}
+
+// update updates the Go's memory limit with the given provider.
+// It returns the previous limit, the new limit and the raw limit recorded by recordRaw.
+// The previous limit is returned even if an error is returned.
+// c.mu must be held.
+func (c *Controller) update(provider Provider) (_ uint64, _ uint64, _ uint64, _err error) {
+	snapshot := debug.SetMemoryLimit(-1)
+	gcSnapshot, _ := readGCPercent()
+	defer rollbackOnPanic(c.logger, snapshot, gcSnapshot, &_err)
+
+	prev := c.currentLimit()
+	limit, err := updateGoMemLimit(prev, provider, c.gc, c.dryRun, c.logger)
+	raw := c.raw.Load()
+	if err != nil {
+		return prev, 0, raw, err
+	}
+	c.limit.Store(limit)
+	if c.dryRun {
+		c.dryLimit = limit
+	}
+
+	return prev, limit, raw, nil
+}
+
+// currentLimit returns the current GOMEMLIMIT, or the one that would have been set in the dry run mode.
+// c.mu must be held.
+func (c *Controller) currentLimit() uint64 {
+	if c.dryRun {
+		return c.dryLimit
+	}
+	return uint64(debug.SetMemoryLimit(-1))
+}
+
+// notify calls the callbacks and sends the change to the channels.
+func (c *Controller) notify(change Change) {
+	for _, fn := range c.onChange {
+		fn(change)
+	}
+	for _, ch := range c.changeChans {
+		select {
+		case ch <- change:
+		default:
+			c.logger.Warn("change channel is not ready, dropping the change", slog.Uint64(envGOMEMLIMIT, change.New))
+		}
+	}
+}
+
+// recordRaw returns a Provider that records the value returned by the given provider,
+// so that it can be reported as Change.Raw.
+func (c *Controller) recordRaw(provider Provider) Provider {
+	return func() (uint64, error) {
+		limit, err := provider()
+		if err != nil {
+			c.raw.Store(0)
+			return 0, err
+		}
+		c.raw.Store(limit)
+		return limit, nil
+	}
+}
+
+func (c *Controller) isStopped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stopped
+}
+
+// teardown marks the Controller as stopped and restores the snapshots if configured.
+func (c *Controller) teardown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stopped = true
+	if c.restore && !c.dryRun {
+		debug.SetMemoryLimit(c.snapshot)
+		c.logger.Info("GOMEMLIMIT is restored", slog.Int64(envGOMEMLIMIT, c.snapshot))
+		if c.gc != nil {
+			debug.SetGCPercent(c.gcSnapshot)
+			c.logger.Info("GOGC is restored", slog.Int(envGOGC, c.gcSnapshot))
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package memlimit
+
+import (
+	"context"
+	"math"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStart(t *testing.T) {
+	t.Cleanup(func() {
+		debug.SetMemoryLimit(math.MaxInt64)
+	})
+
+	var limit atomic.Uint64
+	limit.Store(1024 * 1024 * 1024)
+	c, err := Start(context.Background(),
+		WithProvider(func() (uint64, error) {
+			return limit.Load(), nil
+		}),
+		WithRatio(1),
+		WithRefreshInterval(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer c.Stop()
+
+	if got := c.Limit(); got != 1024*1024*1024 {
+		t.Errorf("Limit() got = %v, want %v", got, 1024*1024*1024)
+	}
+
+	// refresh on demand
+	limit.Store(512 * 1024 * 1024)
+	got, err := c.Refresh()
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if got != 512*1024*1024 {
+		t.Errorf("Refresh() got = %v, want %v", got, 512*1024*1024)
+	}
+	if curr := debug.SetMemoryLimit(-1); curr != 512*1024*1024 {
+		t.Errorf("debug.SetMemoryLimit(-1) got = %v, want %v", curr, 512*1024*1024)
+	}
+
+	// stop refreshing
+	c.Stop()
+	limit.Store(256 * 1024 * 1024)
+	time.Sleep(100 * time.Millisecond)
+
+	if curr := debug.SetMemoryLimit(-1); curr != 512*1024*1024 {
+		t.Errorf("debug.SetMemoryLimit(-1) got = %v, want %v", curr, 512*1024*1024)
+	}
+	if _, err := c.Refresh(); err != ErrStopped {
+		t.Errorf("Refresh() error = %v, want %v", err, ErrStopped)
+	}
+}
+
+func TestStart_WithRestoreOnStop(t *testing.T) {
+	t.Cleanup(func() {
+		debug.SetMemoryLimit(math.MaxInt64)
+	})
+
+	snapshot := int64(987654321)
+	debug.SetMemoryLimit(snapshot)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := Start(ctx,
+		WithProvider(Limit(123456789)),
+		WithRatio(1),
+		WithRefreshInterval(10*time.Millisecond),
+		WithRestoreOnStop(),
+	)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if curr := debug.SetMemoryLimit(-1); curr != 123456789 {
+		t.Errorf("debug.SetMemoryLimit(-1) got = %v, want %v", curr, 123456789)
+	}
+
+	// cancelling the context is equivalent to Stop
+	cancel()
+	c.Stop()
+
+	if curr := debug.SetMemoryLimit(-1); curr != snapshot {
+		t.Errorf("debug.SetMemoryLimit(-1) got = %v, want %v", curr, snapshot)
+	}
+}
+
+func TestStart_Skipped(t *testing.T) {
+	t.Setenv(envAUTOMEMLIMIT, "off")
+
+	c, err := Start(context.Background(), WithProvider(Limit(123456789)))
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer c.Stop()
+
+	if got := c.Limit(); got != 0 {
+		t.Errorf("Limit() got = %v, want %v", got, 0)
+	}
+	if got, err := c.Refresh(); got != 0 || err != nil {
+		t.Errorf("Refresh() got = %v, %v, want %v, %v", got, err, 0, nil)
+	}
+}
+
+func TestStart_WithOnChange(t *testing.T) {
+	t.Cleanup(func() {
+		debug.SetMemoryLimit(math.MaxInt64)
+	})
+
+	var (
+		calls []Change
+		ch    = make(chan Change, 10)
+		limit atomic.Uint64
+	)
+	limit.Store(1000)
+	c, err := Start(context.Background(),
+		WithProvider(func() (uint64, error) {
+			return limit.Load(), nil
+		}),
+		WithRatio(0.5),
+		WithOnChange(func(change Change) {
+			calls = append(calls, change)
+		}),
+		WithChangeChannel(ch),
+	)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer c.Stop()
+
+	// not changed
+	if _, err := c.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	limit.Store(2000)
+	if _, err := c.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	wantChanges := []Change{
+		{Old: math.MaxInt64, New: 500, Raw: 1000, Source: SourceInit},
+		{Old: 500, New: 1000, Raw: 2000, Source: SourceManual},
+	}
+	if len(calls) != len(wantChanges) {
+		t.Fatalf("calls got = %+v, want %+v", calls, wantChanges)
+	}
+	for i := range wantChanges {
+		if calls[i] != wantChanges[i] {
+			t.Errorf("calls[%d] got = %+v, want %+v", i, calls[i], wantChanges[i])
+		}
+	}
+
+	for i, want := range wantChanges {
+		select {
+		case got := <-ch:
+			if got != want {
+				t.Errorf("change[%d] got = %+v, want %+v", i, got, want)
+			}
+		default:
+			t.Fatalf("change[%d] is not received", i)
+		}
+	}
+}
+
+func TestStart_WithHeadroomPolicy(t *testing.T) {
+	t.Cleanup(func() {
+		debug.SetMemoryLimit(math.MaxInt64)
+	})
+
+	// the zero ratio defaults to 0.9, with the minimum headroom on top of it.
+	c, err := Start(context.Background(),
+		WithProvider(Limit(1000*1024*1024)),
+		WithHeadroomPolicy(HeadroomPolicy{MinReserve: 200 * 1024 * 1024}),
+	)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer c.Stop()
+
+	if got := c.Limit(); got != 800*1024*1024 {
+		t.Errorf("Limit() got = %v, want %v", got, 800*1024*1024)
+	}
+}
+
+func TestStart_WithWatch(t *testing.T) {
+	t.Cleanup(func() {
+		debug.SetMemoryLimit(math.MaxInt64)
+	})
+
+	// regardless of whether watching is possible, the Controller should work and stop.
+	c, err := Start(context.Background(),
+		WithProvider(Limit(123456789)),
+		WithRatio(1),
+		WithWatch(),
+	)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if got := c.Limit(); got != 123456789 {
+		t.Errorf("Limit() got = %v, want %v", got, 123456789)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop() did not return")
+	}
+}
+
+func TestStart_ChangeOrder(t *testing.T) {
+	t.Cleanup(func() {
+		debug.SetMemoryLimit(math.MaxInt64)
+	})
+
+	var limit atomic.Uint64
+	limit.Store(1000)
+	ch := make(chan Change, 1000)
+	c, err := Start(context.Background(),
+		WithProvider(func() (uint64, error) {
+			return limit.Add(1), nil
+		}),
+		WithRatio(1),
+		WithChangeChannel(ch),
+	)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer c.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				c.Refresh()
+			}
+		}()
+	}
+	wg.Wait()
+	close(ch)
+
+	var prev Change
+	for change := range ch {
+		if prev.New != 0 && change.Old != prev.New {
+			t.Fatalf("change got = %+v after %+v, want Old %v", change, prev, prev.New)
+		}
+		if change.Raw != change.New {
+			t.Errorf("change got = %+v, want Raw %v", change, change.New)
+		}
+		prev = change
+	}
+}
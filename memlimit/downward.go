@@ -0,0 +1,83 @@
+package memlimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FromDownwardAPIFile returns a Provider that reads the memory limit from the file
+// projected by the Kubernetes Downward API (resources.limits.memory).
+// The content is parsed as a Kubernetes resource quantity, e.g. "536870912", "512Mi", "1G" or "2e9".
+// It returns ErrNoLimit if the file is empty or the limit is 0.
+//
+// Note that the Downward API reports the allocatable memory of the node if the container has no memory limit.
+func FromDownwardAPIFile(path string) Provider {
+	return FromContextProvider(FromDownwardAPIFileContext(path))
+}
+
+// FromDownwardAPIFileContext returns a ContextProvider that reads the memory limit in the same way as FromDownwardAPIFile.
+// The Result reports the path of the file as the source and its content as the raw value.
+func FromDownwardAPIFileContext(path string) ContextProvider {
+	return ContextProviderFunc(func(ctx context.Context) (Result, error) {
+		if err := ctx.Err(); err != nil {
+			return Result{}, err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		raw := strings.TrimSpace(string(b))
+		limit, err := parseLimitQuantity(raw)
+		if err != nil {
+			return Result{}, err
+		}
+		return Result{Value: limit, Source: path, Raw: raw}, nil
+	})
+}
+
+// FromEnvVar returns a Provider that reads the memory limit from the given environment variable,
+// e.g. the one set from resources.limits.memory by the Kubernetes Downward API.
+// The value is parsed as a Kubernetes resource quantity, e.g. "536870912", "512Mi", "1G" or "2e9".
+// It returns ErrNoLimit if the environment variable is not set or empty, or the limit is 0.
+func FromEnvVar(name string) Provider {
+	return FromContextProvider(FromEnvVarContext(name))
+}
+
+// FromEnvVarContext returns a ContextProvider that reads the memory limit in the same way as FromEnvVar.
+// The Result reports the name of the environment variable as the source and its value as the raw value.
+func FromEnvVarContext(name string) ContextProvider {
+	return ContextProviderFunc(func(ctx context.Context) (Result, error) {
+		if err := ctx.Err(); err != nil {
+			return Result{}, err
+		}
+		raw := os.Getenv(name)
+		limit, err := parseLimitQuantity(raw)
+		if err != nil {
+			if errors.Is(err, ErrNoLimit) {
+				return Result{}, err
+			}
+			return Result{}, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		return Result{Value: limit, Source: name, Raw: raw}, nil
+	})
+}
+
+// parseLimitQuantity parses the memory limit as a Kubernetes resource quantity.
+// It returns ErrNoLimit if the value is empty or 0.
+func parseLimitQuantity(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, ErrNoLimit
+	}
+	limit, err := parseQuantity(s)
+	if err != nil {
+		return 0, err
+	}
+	if limit == 0 {
+		return 0, ErrNoLimit
+	}
+	return limit, nil
+}
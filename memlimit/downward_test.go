@@ -0,0 +1,114 @@
+package memlimit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFromDownwardAPIFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    uint64
+		wantErr error
+	}{
+		{name: "bytes", content: "536870912\n", want: 512 << 20},
+		{name: "quantity", content: "512Mi", want: 512 << 20},
+		{name: "empty", content: "", wantErr: ErrNoLimit},
+		{name: "zero", content: "0\n", wantErr: ErrNoLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "mem_limit")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := FromDownwardAPIFile(path)()
+			if err != tt.wantErr {
+				t.Errorf("FromDownwardAPIFile() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("FromDownwardAPIFile() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("not exist", func(t *testing.T) {
+		limit, err := ApplyFallback(FromDownwardAPIFile(filepath.Join(t.TempDir(), "mem_limit")), Limit(1024))()
+		if err != nil || limit != 1024 {
+			t.Errorf("ApplyFallback() got = %v, %v, want %v, %v", limit, err, 1024, nil)
+		}
+	})
+}
+
+func TestFromEnvVar(t *testing.T) {
+	const name = "AUTOMEMLIMIT_TEST_MEMORY_LIMIT"
+
+	tests := []struct {
+		name    string
+		value   string
+		want    uint64
+		wantErr bool
+	}{
+		{name: "quantity", value: "1G", want: 1000000000},
+		{name: "exponent", value: "2e9", want: 2000000000},
+		{name: "invalid", value: "1GB", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(name, tt.value)
+			got, err := FromEnvVar(name)()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("FromEnvVar() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("FromEnvVar() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("unset", func(t *testing.T) {
+		if _, err := FromEnvVar(name)(); err != ErrNoLimit {
+			t.Errorf("FromEnvVar() error = %v, wantErr %v", err, ErrNoLimit)
+		}
+	})
+}
+
+func TestFromDownwardAPIFileContext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mem_limit")
+	if err := os.WriteFile(path, []byte("512Mi\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := FromDownwardAPIFileContext(path).Limit(context.Background())
+	if err != nil {
+		t.Fatalf("Limit() error = %v", err)
+	}
+	want := Result{Value: 512 << 20, Source: path, Raw: "512Mi"}
+	if got != want {
+		t.Errorf("Limit() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestFromEnvVarContext(t *testing.T) {
+	const name = "AUTOMEMLIMIT_TEST_MEMORY_LIMIT"
+
+	t.Setenv(name, "1G")
+	got, err := FromEnvVarContext(name).Limit(context.Background())
+	if err != nil {
+		t.Fatalf("Limit() error = %v", err)
+	}
+	want := Result{Value: 1000000000, Source: name, Raw: "1G"}
+	if got != want {
+		t.Errorf("Limit() got = %+v, want %+v", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := FromEnvVarContext(name).Limit(ctx); err != context.Canceled {
+		t.Errorf("Limit() error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,265 @@
+package memlimit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryEvents are the counters of the cgroup v2 memory.events file.
+// See https://docs.kernel.org/admin-guide/cgroup-v2.html#memory-interface-files
+type MemoryEvents struct {
+	// Low is the number of times the cgroup was reclaimed despite being under the low boundary.
+	Low uint64
+	// High is the number of times the processes of the cgroup were throttled for exceeding memory.high.
+	High uint64
+	// Max is the number of times the memory usage of the cgroup was about to go over memory.max.
+	Max uint64
+	// OOM is the number of times the memory usage of the cgroup hit the limit and allocation failed.
+	OOM uint64
+	// OOMKill is the number of processes of the cgroup killed by the OOM killer.
+	OOMKill uint64
+}
+
+// MemoryEventsChange describes an increase of the counters of memory.events.
+type MemoryEventsChange struct {
+	// Old is the counters before the increase.
+	Old MemoryEvents
+	// New is the counters after the increase.
+	New MemoryEvents
+}
+
+// MemoryEventsPolicy configures the memory.events watchdog. See WithMemoryEventsWatchdog.
+type MemoryEventsPolicy struct {
+	// Interval is how often memory.events is read.
+	//
+	// Default: 5s
+	Interval time.Duration
+	// FreeOSMemory calls debug.FreeOSMemory when the max counter increases.
+	//
+	// Default: false
+	FreeOSMemory bool
+	// Cut is the ratio by which GOMEMLIMIT is temporarily lowered when the max counter increases,
+	// in the half-open range [0.0,1.0). 0 disables the cut.
+	//
+	// Default: 0
+	Cut float64
+	// CutDuration is how long the cut lasts after the last increase of the max counter.
+	//
+	// Default: 1m
+	CutDuration time.Duration
+	// OnEvents is called when any of the high, max, oom and oom_kill counters increases.
+	// It is called synchronously from the watchdog goroutine, so it should not block for a long time.
+	//
+	// Default: nil
+	OnEvents func(MemoryEventsChange)
+}
+
+// withDefaults returns the policy with the default values for the unset fields.
+func (p MemoryEventsPolicy) withDefaults() MemoryEventsPolicy {
+	if p.Interval == 0 {
+		p.Interval = 5 * time.Second
+	}
+	if p.CutDuration == 0 {
+		p.CutDuration = time.Minute
+	}
+	return p
+}
+
+// parseMemoryEvents parses the content of memory.events.
+//
+//	low 0
+//	high 0
+//	max 0
+//	oom 0
+//	oom_kill 0
+func parseMemoryEvents(b []byte) (MemoryEvents, error) {
+	var (
+		events MemoryEvents
+		s      = bufio.NewScanner(bytes.NewReader(b))
+	)
+	for s.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(s.Text()), " ")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return MemoryEvents{}, fmt.Errorf("failed to parse memory.events %q: %w", s.Text(), err)
+		}
+		switch key {
+		case "low":
+			events.Low = n
+		case "high":
+			events.High = n
+		case "max":
+			events.Max = n
+		case "oom":
+			events.OOM = n
+		case "oom_kill":
+			events.OOMKill = n
+		}
+	}
+	if err := s.Err(); err != nil {
+		return MemoryEvents{}, err
+	}
+
+	return events, nil
+}
+
+// readMemoryEvents reads memory.events of the cgroup v2 directory of the process.
+// newMemoryEventsReader locates memory.events of the cgroup v2 once,
+// and returns a function that reads its counters.
+func newMemoryEventsReader(opts CgroupOptions) func() (MemoryEvents, error) {
+	opts = opts.withDefaults()
+	file, err := memoryEventsFile(opts)
+	return func() (MemoryEvents, error) {
+		if err != nil {
+			return MemoryEvents{}, err
+		}
+		b, err := opts.readFile(file)
+		if err != nil {
+			return MemoryEvents{}, fmt.Errorf("failed to read memory.events: %w", err)
+		}
+		return parseMemoryEvents(b)
+	}
+}
+
+// memoryEventsFile returns the path of memory.events of the cgroup v2.
+func memoryEventsFile(opts CgroupOptions) (string, error) {
+	mis, chs, err := readCgroupProcFiles(opts)
+	if err != nil {
+		return "", err
+	}
+	if _, v2 := detectCgroupVersion(mis); !v2 {
+		return "", errors.New("memory.events is only available in cgroup v2")
+	}
+	loc, err := locateCgroupV2(opts, chs, mis)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(loc.path, "memory.events"), nil
+}
+
+// memoryEventsCut is the temporary cut of GOMEMLIMIT made by the memory.events watchdog.
+type memoryEventsCut struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+func (c *memoryEventsCut) set(until time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.until = until
+}
+
+func (c *memoryEventsCut) active(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return now.Before(c.until)
+}
+
+// applyMemoryEventsCut returns a Provider that lowers the limit of the given provider by the ratio while the cut is active.
+func applyMemoryEventsCut(provider Provider, ratio float64, cut *memoryEventsCut) Provider {
+	return func() (uint64, error) {
+		if ratio < 0 || ratio >= 1 {
+			return 0, fmt.Errorf("invalid memory events cut: %f, cut should be in the range [0.0,1.0)", ratio)
+		}
+		limit, err := provider()
+		if err != nil {
+			return 0, err
+		}
+		if cut.active(time.Now()) {
+			return uint64(float64(limit) * (1 - ratio)), nil
+		}
+		return limit, nil
+	}
+}
+
+// watchMemoryEvents reads the memory.events counters every policy.Interval, and logs and reports their increases.
+// When the max counter increases, it frees the OS memory and starts the cut as configured.
+// It sends to the returned channel when the cut starts or ends, so that GOMEMLIMIT is reapplied.
+// The channel is closed when the context is done or memory.events cannot be read at the start.
+// The goroutine watching the counters is added to wg.
+func watchMemoryEvents(ctx context.Context, wg *sync.WaitGroup, policy MemoryEventsPolicy, cut *memoryEventsCut, read func() (MemoryEvents, error), logger *slog.Logger) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	prev, err := read()
+	if err != nil {
+		logger.Warn("failed to read memory.events, disabling the watchdog", slog.Any("error", err))
+		close(ch)
+		return ch
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(ch)
+
+		t := time.NewTicker(policy.Interval)
+		defer t.Stop()
+
+		var cutting bool
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+
+			notify := false
+			curr, err := read()
+			if err != nil {
+				logger.Error("failed to read memory.events", slog.Any("error", err))
+			} else if memoryEventsIncreased(prev, curr) {
+				logger.Warn("memory events increased",
+					slog.Uint64("high", curr.High-min(prev.High, curr.High)),
+					slog.Uint64("max", curr.Max-min(prev.Max, curr.Max)),
+					slog.Uint64("oom", curr.OOM-min(prev.OOM, curr.OOM)),
+					slog.Uint64("oom_kill", curr.OOMKill-min(prev.OOMKill, curr.OOMKill)),
+				)
+				if policy.OnEvents != nil {
+					policy.OnEvents(MemoryEventsChange{Old: prev, New: curr})
+				}
+				if curr.Max > prev.Max {
+					if policy.FreeOSMemory {
+						debug.FreeOSMemory()
+					}
+					if policy.Cut > 0 {
+						cut.set(time.Now().Add(policy.CutDuration))
+						cutting, notify = true, true
+					}
+				}
+			}
+			if err == nil {
+				// the counters can also decrease, e.g. when the cgroup is recreated.
+				prev = curr
+			}
+			if cutting && !cut.active(time.Now()) {
+				cutting, notify = false, true
+			}
+
+			if notify {
+				select {
+				case ch <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+// memoryEventsIncreased reports whether any of the high, max, oom and oom_kill counters has increased.
+func memoryEventsIncreased(prev, curr MemoryEvents) bool {
+	return curr.High > prev.High || curr.Max > prev.Max || curr.OOM > prev.OOM || curr.OOMKill > prev.OOMKill
+}
@@ -0,0 +1,95 @@
+package memlimit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseMemoryEvents(t *testing.T) {
+	got, err := parseMemoryEvents([]byte("low 1\nhigh 2\nmax 3\noom 4\noom_kill 5\noom_group_kill 6\n"))
+	if err != nil {
+		t.Fatalf("parseMemoryEvents() error = %v", err)
+	}
+	if want := (MemoryEvents{Low: 1, High: 2, Max: 3, OOM: 4, OOMKill: 5}); got != want {
+		t.Errorf("parseMemoryEvents() got = %+v, want %+v", got, want)
+	}
+
+	if _, err := parseMemoryEvents([]byte("max abc\n")); err == nil {
+		t.Errorf("parseMemoryEvents() error = %v, wantErr %v", err, true)
+	}
+}
+
+func TestWatchMemoryEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		events  atomic.Pointer[MemoryEvents]
+		mu      sync.Mutex
+		changes []MemoryEventsChange
+		cut     = &memoryEventsCut{}
+		wg      sync.WaitGroup
+	)
+	events.Store(&MemoryEvents{})
+	ch := watchMemoryEvents(ctx, &wg,
+		MemoryEventsPolicy{
+			Interval:    5 * time.Millisecond,
+			Cut:         0.5,
+			CutDuration: 50 * time.Millisecond,
+			OnEvents: func(c MemoryEventsChange) {
+				mu.Lock()
+				defer mu.Unlock()
+				changes = append(changes, c)
+			},
+		},
+		cut,
+		func() (MemoryEvents, error) {
+			return *events.Load(), nil
+		},
+		slog.New(noopLogger{}),
+	)
+	provider := applyMemoryEventsCut(Limit(1000), 0.5, cut)
+
+	events.Store(&MemoryEvents{Max: 1})
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the cut did not start")
+	}
+	if got, _ := provider(); got != 500 {
+		t.Errorf("provider() got = %v, want %v", got, 500)
+	}
+
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the cut did not end")
+	}
+	if got, _ := provider(); got != 1000 {
+		t.Errorf("provider() got = %v, want %v", got, 1000)
+	}
+
+	// neither the low counter nor the counters reset by recreating the cgroup are reported.
+	events.Store(&MemoryEvents{Low: 1, Max: 1})
+	time.Sleep(50 * time.Millisecond)
+	events.Store(&MemoryEvents{})
+	time.Sleep(50 * time.Millisecond)
+
+	// the watchdog exits when the context is done.
+	cancel()
+	wg.Wait()
+	if _, ok := <-ch; ok {
+		t.Error("the channel is not closed")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := MemoryEventsChange{Old: MemoryEvents{}, New: MemoryEvents{Max: 1}}
+	if len(changes) != 1 || changes[0] != want {
+		t.Errorf("changes got = %+v, want %+v", changes, []MemoryEventsChange{want})
+	}
+}
@@ -0,0 +1,172 @@
+package memlimit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileOption configures FromFile.
+type FileOption func(cfg *fileConfig)
+
+type fileConfig struct {
+	missing Provider
+}
+
+// WithMissingFile sets the provider used when the file does not exist.
+// For example, WithMissingFile(FromCgroup) falls back to the cgroup until the file is created.
+// To treat a missing file as no limit, use a provider that returns ErrNoLimit.
+//
+// Default: the missing file is reported as an error wrapping os.ErrNotExist.
+func WithMissingFile(provider Provider) FileOption {
+	return func(cfg *fileConfig) {
+		cfg.missing = provider
+	}
+}
+
+// FromFile returns a Provider that reads the memory limit from the given file.
+//
+// The first line that is neither empty nor a comment is used as the limit.
+// Comments start with '#' and continue to the end of the line.
+// The limit is a size in bytes with an optional unit, e.g. "4294967296", "4GiB", "512Mi" or "1G",
+// and "max" means no limit (ErrNoLimit).
+//
+// An empty file also means no limit when it is read first. Once a limit has been read, an empty file is
+// reported as an error so that the previous limit is kept, since the file is likely being rewritten in place
+// (truncated and then written). Write "max" to remove the limit instead.
+//
+// The file is parsed again only if its modification time, change time, inode or size has changed,
+// so that it can be refreshed frequently. A file modified within the last second is always parsed again,
+// since rewriting it within the granularity of the file timestamps may not change them.
+func FromFile(path string, opts ...FileOption) Provider {
+	return FromContextProvider(FromFileContext(path, opts...))
+}
+
+// FromFileContext returns a ContextProvider that reads the memory limit in the same way as FromFile.
+// The Result reports the path of the file as the source and the line the limit was parsed from as the raw value.
+// If the file does not exist and WithMissingFile is given, the Result of the fallback provider only has the value.
+func FromFileContext(path string, opts ...FileOption) ContextProvider {
+	cfg := &fileConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	var (
+		mu     sync.Mutex
+		cached bool
+		key    fileKey
+		read   bool
+		res    Result
+		err    error
+	)
+	return ContextProviderFunc(func(ctx context.Context) (Result, error) {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return Result{}, ctxErr
+		}
+
+		now := time.Now()
+		fi, statErr := os.Stat(path)
+		if statErr != nil {
+			if errors.Is(statErr, os.ErrNotExist) && cfg.missing != nil {
+				return cfg.missingResult()
+			}
+			return Result{}, fmt.Errorf("failed to stat %s: %w", path, statErr)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		k := newFileKey(fi)
+		if cached && k == key {
+			return res, err
+		}
+
+		b, readErr := os.ReadFile(path)
+		if readErr != nil {
+			if errors.Is(readErr, os.ErrNotExist) && cfg.missing != nil {
+				return cfg.missingResult()
+			}
+			return Result{}, fmt.Errorf("failed to read %s: %w", path, readErr)
+		}
+		if len(b) == 0 && read {
+			cached = false
+			return Result{}, fmt.Errorf("%s is empty, it may be being rewritten; write \"max\" to remove the limit", path)
+		}
+
+		read = true
+		cached, key = now.Sub(time.Unix(0, max(k.modTime, k.ctime))) > racyFileWindow, k
+		limit, line, parseErr := parseLimitFile(b)
+		res, err = Result{Value: limit, Source: path, Raw: line}, parseErr
+		if err != nil {
+			res = Result{}
+			if !errors.Is(err, ErrNoLimit) {
+				err = fmt.Errorf("failed to parse %s: %w", path, err)
+			}
+		}
+		return res, err
+	})
+}
+
+// racyFileWindow is how long after the last modification the content of the file read by FromFile is not cached.
+const racyFileWindow = time.Second
+
+// fileKey identifies the content of the file read by FromFile.
+type fileKey struct {
+	size    int64
+	modTime int64
+	// ino and ctime are only available on Linux, and 0 otherwise.
+	ino   uint64
+	ctime int64
+}
+
+func newFileKey(fi os.FileInfo) fileKey {
+	k := fileKey{size: fi.Size(), modTime: fi.ModTime().UnixNano()}
+	k.ino, k.ctime = fileInodeAndCtime(fi)
+	return k
+}
+
+// missingResult returns the limit of the provider configured by WithMissingFile.
+func (cfg *fileConfig) missingResult() (Result, error) {
+	limit, err := cfg.missing()
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Value: limit}, nil
+}
+
+// parseLimitFile parses the content of the file read by FromFile.
+// It also returns the line that the limit was parsed from.
+func parseLimitFile(b []byte) (uint64, string, error) {
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line == "max" {
+			return 0, line, ErrNoLimit
+		}
+
+		limit, err := parseSize(line)
+		if err != nil {
+			// accept the Kubernetes resource quantity as well, e.g. "1G".
+			var qerr error
+			if limit, qerr = parseQuantity(line); qerr != nil {
+				return 0, line, err
+			}
+		}
+		return limit, line, nil
+	}
+	if err := s.Err(); err != nil {
+		return 0, "", err
+	}
+
+	return 0, "", ErrNoLimit
+}
@@ -0,0 +1,18 @@
+//go:build linux
+// +build linux
+
+package memlimit
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInodeAndCtime returns the inode number and the change time in nanoseconds of the file.
+func fileInodeAndCtime(fi os.FileInfo) (uint64, int64) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return uint64(st.Ino), st.Ctim.Nano()
+}
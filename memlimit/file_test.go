@@ -0,0 +1,105 @@
+package memlimit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseLimitFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    uint64
+		wantErr error
+	}{
+		{name: "bytes", content: "4294967296\n", want: 4 << 30},
+		{name: "unit", content: "4GiB", want: 4 << 30},
+		{name: "quantity", content: "1G", want: 1000000000},
+		{name: "comments", content: "# limit for the worker\n\n512Mi # half of the pod\n1Gi\n", want: 512 << 20},
+		{name: "max", content: "max\n", wantErr: ErrNoLimit},
+		{name: "empty", content: "", wantErr: ErrNoLimit},
+		{name: "only comments", content: "# no limit\n", wantErr: ErrNoLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := parseLimitFile([]byte(tt.content))
+			if err != tt.wantErr {
+				t.Errorf("parseLimitFile() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseLimitFile() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, _, err := parseLimitFile([]byte("4XB")); err == nil {
+		t.Errorf("parseLimitFile() error = %v, wantErr %v", err, true)
+	}
+}
+
+func TestFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limit.txt")
+
+	// missing file
+	if _, err := FromFile(path)(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("FromFile() error = %v, want %v", err, os.ErrNotExist)
+	}
+	if got, err := FromFile(path, WithMissingFile(Limit(1024)))(); got != 1024 || err != nil {
+		t.Errorf("FromFile() got = %v, %v, want %v, %v", got, err, 1024, nil)
+	}
+
+	provider := FromFile(path)
+	if err := os.WriteFile(path, []byte("1GiB\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := provider(); got != 1<<30 || err != nil {
+		t.Errorf("FromFile() got = %v, %v, want %v, %v", got, err, 1<<30, nil)
+	}
+	res, err := FromFileContext(path).Limit(context.Background())
+	if want := (Result{Value: 1 << 30, Source: path, Raw: "1GiB"}); res != want || err != nil {
+		t.Errorf("FromFileContext() got = %+v, %v, want %+v, %v", res, err, want, nil)
+	}
+
+	// a rewrite of the same size is detected even if the modification time is unchanged.
+	mtime := time.Now().Add(-time.Hour)
+	if err := os.WriteFile(path, []byte("2GiB\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := provider(); got != 2<<30 || err != nil {
+		t.Errorf("FromFile() got = %v, %v, want %v, %v", got, err, 2<<30, nil)
+	}
+	if err := os.WriteFile(path, []byte("3GiB\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := provider(); got != 3<<30 || err != nil {
+		t.Errorf("FromFile() got = %v, %v, want %v, %v", got, err, 3<<30, nil)
+	}
+
+	// an empty file being rewritten keeps the previous limit, and "max" removes it.
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider(); err == nil || errors.Is(err, ErrNoLimit) {
+		t.Errorf("FromFile() error = %v, want an error other than %v", err, ErrNoLimit)
+	}
+	if _, err := FromFile(path)(); err != ErrNoLimit {
+		t.Errorf("FromFile() error = %v, want %v", err, ErrNoLimit)
+	}
+	if err := os.WriteFile(path, []byte("max\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider(); err != ErrNoLimit {
+		t.Errorf("FromFile() error = %v, want %v", err, ErrNoLimit)
+	}
+}
@@ -0,0 +1,169 @@
+package memlimit
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"runtime/debug"
+	"runtime/metrics"
+)
+
+const (
+	metricGCPercent = "/gc/gogc:percent"
+	metricLiveHeap  = "/gc/heap/live:bytes"
+
+	envGOGC = "GOGC"
+)
+
+// GCPercentMode determines how GOGC is set alongside GOMEMLIMIT. See GCPercentPolicy.
+type GCPercentMode int
+
+const (
+	// GCPercentUnchanged leaves GOGC as is.
+	GCPercentUnchanged GCPercentMode = iota
+	// GCPercentOffWhenLimited turns GOGC off while the memory is limited,
+	// so that the GC runs only when the heap approaches GOMEMLIMIT.
+	// The original GOGC is restored when the memory is not limited.
+	GCPercentOffWhenLimited
+	// GCPercentProportional sets GOGC proportional to the headroom between the live heap and GOMEMLIMIT,
+	// i.e. 100*(GOMEMLIMIT-live)/live clamped to [Min,Max], so that the GC runs less often when the
+	// live heap is far below the limit. It is re-evaluated whenever GOMEMLIMIT is refreshed,
+	// so it should be used with WithRefreshInterval to follow the live heap.
+	// Until a GC has completed, the live heap is unknown and the original GOGC is kept.
+	GCPercentProportional
+)
+
+// GCPercentPolicy is a policy that determines GOGC set alongside GOMEMLIMIT.
+type GCPercentPolicy struct {
+	// Mode is how GOGC is set.
+	Mode GCPercentMode
+	// Min is the minimum GOGC for GCPercentProportional.
+	// Since 0 means the default, set a negative value (e.g. -1) for a minimum of 0.
+	//
+	// Default: 50
+	Min int
+	// Max is the maximum GOGC for GCPercentProportional.
+	//
+	// Default: 400
+	Max int
+}
+
+// withDefaults returns the policy with the default values for the unset fields.
+func (p GCPercentPolicy) withDefaults() GCPercentPolicy {
+	switch {
+	case p.Min == 0:
+		p.Min = 50
+	case p.Min < 0:
+		p.Min = 0
+	}
+	if p.Max == 0 {
+		p.Max = 400
+	}
+	return p
+}
+
+// gcPercentFor returns GOGC for the given GOMEMLIMIT and live heap.
+// base is the GOGC to use when the memory is not limited.
+func gcPercentFor(policy GCPercentPolicy, limit uint64, base int, live uint64) (int, error) {
+	switch policy.Mode {
+	case GCPercentUnchanged:
+		return base, nil
+	case GCPercentOffWhenLimited:
+		if limit >= math.MaxInt64 {
+			return base, nil
+		}
+		return -1, nil
+	case GCPercentProportional:
+		if policy.Min < 0 || policy.Max < policy.Min {
+			return 0, fmt.Errorf("invalid GC percent policy: min %d, max %d", policy.Min, policy.Max)
+		}
+		if limit >= math.MaxInt64 {
+			return base, nil
+		}
+		if live == 0 {
+			// no GC has been completed yet.
+			return base, nil
+		}
+		if live >= limit {
+			return policy.Min, nil
+		}
+		percent := 100 * (float64(limit) - float64(live)) / float64(live)
+		return int(max(float64(policy.Min), min(percent, float64(policy.Max)))), nil
+	default:
+		return 0, fmt.Errorf("unknown GC percent mode: %d", policy.Mode)
+	}
+}
+
+// gcPercentSetter sets GOGC by the policy alongside GOMEMLIMIT.
+type gcPercentSetter struct {
+	policy GCPercentPolicy
+	// base is GOGC before automemlimit changed it.
+	base int
+	// dryPercent is GOGC that would have been set in the dry run mode.
+	dryPercent int
+}
+
+func newGCPercentSetter(policy GCPercentPolicy, base int) *gcPercentSetter {
+	return &gcPercentSetter{policy: policy, base: base, dryPercent: base}
+}
+
+// gcPercentUpdate is GOGC to be set by gcPercentSetter.
+type gcPercentUpdate struct {
+	percent  int
+	previous int
+	live     uint64
+}
+
+// next returns GOGC for the given GOMEMLIMIT, and whether it differs from the current GOGC.
+// If dryRun is true, it is compared with GOGC that would have been set instead.
+func (s *gcPercentSetter) next(limit uint64, dryRun bool) (gcPercentUpdate, bool, error) {
+	if s == nil || s.policy.Mode == GCPercentUnchanged {
+		return gcPercentUpdate{}, false, nil
+	}
+
+	curr, live := readGCPercent()
+	if dryRun {
+		curr = s.dryPercent
+	}
+	percent, err := gcPercentFor(s.policy, limit, s.base, live)
+	if err != nil {
+		return gcPercentUpdate{}, false, err
+	}
+	return gcPercentUpdate{percent: percent, previous: curr, live: live}, percent != curr, nil
+}
+
+// apply sets GOGC returned by next.
+// If dryRun is true, it only logs GOGC that would have been set.
+func (s *gcPercentSetter) apply(u gcPercentUpdate, dryRun bool, logger *slog.Logger) {
+	if dryRun {
+		s.dryPercent = u.percent
+		logger.Info("GOGC would be updated (dry run)", slog.Int(envGOGC, u.percent), slog.Int("previous", u.previous), slog.Uint64("live_heap", u.live))
+		return
+	}
+
+	debug.SetGCPercent(u.percent)
+	logger.Info("GOGC is updated", slog.Int(envGOGC, u.percent), slog.Int("previous", u.previous), slog.Uint64("live_heap", u.live))
+}
+
+// readGCPercent returns the current GOGC (-1 if off) and the live heap size.
+func readGCPercent() (int, uint64) {
+	samples := []metrics.Sample{
+		{Name: metricGCPercent},
+		{Name: metricLiveHeap},
+	}
+	metrics.Read(samples)
+
+	percent, live := 100, uint64(0)
+	if samples[0].Value.Kind() == metrics.KindUint64 {
+		if v := samples[0].Value.Uint64(); v > math.MaxInt32 {
+			percent = -1
+		} else {
+			percent = int(v)
+		}
+	}
+	if samples[1].Value.Kind() == metrics.KindUint64 {
+		live = samples[1].Value.Uint64()
+	}
+
+	return percent, live
+}
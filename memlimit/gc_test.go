@@ -0,0 +1,190 @@
+package memlimit
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"reflect"
+	"runtime/debug"
+	"sync"
+	"testing"
+)
+
+func TestGCPercentFor(t *testing.T) {
+	proportional := GCPercentPolicy{Mode: GCPercentProportional}.withDefaults()
+	tests := []struct {
+		name    string
+		policy  GCPercentPolicy
+		limit   uint64
+		live    uint64
+		want    int
+		wantErr bool
+	}{
+		{name: "unchanged", policy: GCPercentPolicy{Mode: GCPercentUnchanged}, limit: 1 << 30, want: 100},
+		{name: "off when limited", policy: GCPercentPolicy{Mode: GCPercentOffWhenLimited}, limit: 1 << 30, want: -1},
+		{name: "off when limited no limit", policy: GCPercentPolicy{Mode: GCPercentOffWhenLimited}, limit: math.MaxInt64, want: 100},
+		{name: "proportional", policy: proportional, limit: 1 << 30, live: 256 << 20, want: 300},
+		{name: "proportional max", policy: proportional, limit: 1 << 30, live: 64 << 20, want: 400},
+		{name: "proportional min", policy: proportional, limit: 1 << 30, live: 900 << 20, want: 50},
+		{name: "proportional over limit", policy: proportional, limit: 1 << 30, live: 2 << 30, want: 50},
+		{name: "proportional no gc", policy: proportional, limit: 1 << 30, want: 100},
+		{name: "proportional min zero", policy: GCPercentPolicy{Mode: GCPercentProportional, Min: -1}.withDefaults(), limit: 1 << 30, live: 2 << 30, want: 0},
+		{name: "proportional no limit", policy: proportional, limit: math.MaxInt64, live: 256 << 20, want: 100},
+		{name: "invalid", policy: GCPercentPolicy{Mode: GCPercentProportional, Min: 200, Max: 100}, limit: 1 << 30, wantErr: true},
+		{name: "unknown", policy: GCPercentPolicy{Mode: 100}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := gcPercentFor(tt.policy, tt.limit, 100, tt.live)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("gcPercentFor() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("gcPercentFor() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetGoMemLimitWithOpts_WithGCPercentPolicy(t *testing.T) {
+	t.Cleanup(func() {
+		debug.SetMemoryLimit(math.MaxInt64)
+		debug.SetGCPercent(100)
+	})
+
+	_, err := SetGoMemLimitWithOpts(
+		WithProvider(Limit(1<<30)),
+		WithGCPercentPolicy(GCPercentPolicy{Mode: GCPercentOffWhenLimited}),
+	)
+	if err != nil {
+		t.Fatalf("SetGoMemLimitWithOpts() error = %v", err)
+	}
+	if got, _ := readGCPercent(); got != -1 {
+		t.Errorf("GOGC got = %v, want %v", got, -1)
+	}
+}
+
+func TestSetGoMemLimitWithOpts_WithGCPercentPolicy_rollbackOnPanic(t *testing.T) {
+	t.Cleanup(func() {
+		debug.SetMemoryLimit(math.MaxInt64)
+		debug.SetGCPercent(100)
+	})
+
+	debug.SetGCPercent(150)
+	_, err := SetGoMemLimitWithOpts(
+		WithProvider(func() (uint64, error) {
+			debug.SetGCPercent(-1)
+			panic("panic")
+		}),
+		WithGCPercentPolicy(GCPercentPolicy{Mode: GCPercentOffWhenLimited}),
+	)
+	if err == nil {
+		t.Error("SetGoMemLimitWithOpts() error = nil, want panic")
+	}
+	if got, _ := readGCPercent(); got != 150 {
+		t.Errorf("GOGC got = %v, want %v", got, 150)
+	}
+}
+
+// messageRecorder is a slog.Handler that records the messages.
+type messageRecorder struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (r *messageRecorder) Enabled(context.Context, slog.Level) bool { return true }
+
+func (r *messageRecorder) Handle(_ context.Context, record slog.Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, record.Message)
+	return nil
+}
+
+func (r *messageRecorder) WithAttrs([]slog.Attr) slog.Handler { return r }
+
+func (r *messageRecorder) WithGroup(string) slog.Handler { return r }
+
+func TestUpdateGoMemLimit_GCPercentOrder(t *testing.T) {
+	t.Cleanup(func() {
+		debug.SetMemoryLimit(math.MaxInt64)
+		debug.SetGCPercent(100)
+	})
+
+	tests := []struct {
+		name     string
+		curr     uint64
+		gogc     int
+		provider Provider
+		want     []string
+	}{
+		{
+			name:     "limited",
+			curr:     math.MaxInt64,
+			gogc:     100,
+			provider: Limit(1 << 30),
+			want:     []string{"GOMEMLIMIT is updated", "GOGC is updated"},
+		},
+		{
+			name:     "unlimited",
+			curr:     1 << 30,
+			gogc:     -1,
+			provider: Limit(math.MaxInt64),
+			want:     []string{"GOGC is updated", "GOMEMLIMIT is updated"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			debug.SetMemoryLimit(int64(tt.curr))
+			debug.SetGCPercent(tt.gogc)
+
+			recorder := &messageRecorder{}
+			gc := newGCPercentSetter(GCPercentPolicy{Mode: GCPercentOffWhenLimited}, 100)
+			if _, err := updateGoMemLimit(tt.curr, tt.provider, gc, false, slog.New(recorder)); err != nil {
+				t.Fatalf("updateGoMemLimit() error = %v", err)
+			}
+			if !reflect.DeepEqual(recorder.messages, tt.want) {
+				t.Errorf("updateGoMemLimit() messages = %v, want %v", recorder.messages, tt.want)
+			}
+		})
+	}
+}
+
+func TestStart_WithGCPercentPolicy_DryRun(t *testing.T) {
+	t.Cleanup(func() {
+		debug.SetMemoryLimit(math.MaxInt64)
+		debug.SetGCPercent(100)
+	})
+
+	recorder := &messageRecorder{}
+	c, err := Start(context.Background(),
+		WithProvider(Limit(1<<30)),
+		WithGCPercentPolicy(GCPercentPolicy{Mode: GCPercentOffWhenLimited}),
+		WithDryRun(),
+		WithLogger(slog.New(recorder)),
+	)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer c.Stop()
+	for i := 0; i < 3; i++ {
+		if _, err := c.Refresh(); err != nil {
+			t.Fatalf("Refresh() error = %v", err)
+		}
+	}
+
+	// GOGC that would have been set is remembered, so it is not reported again on every refresh.
+	var got int
+	for _, msg := range recorder.messages {
+		if msg == "GOGC would be updated (dry run)" {
+			got++
+		}
+	}
+	if got != 1 {
+		t.Errorf("GOGC dry run messages got = %v, want %v", got, 1)
+	}
+	if gogc, _ := readGCPercent(); gogc != 100 {
+		t.Errorf("GOGC got = %v, want %v", gogc, 100)
+	}
+}
@@ -0,0 +1,92 @@
+package memlimit
+
+// CgroupInfo describes how the memory limit was discovered from the cgroup.
+// See InspectCgroup.
+type CgroupInfo struct {
+	// V1 and V2 are the cgroup versions detected from /proc/self/mountinfo.
+	V1, V2 bool
+	// Version is the cgroup version that the limit was read from.
+	// It is 0 if no cgroup directory was located.
+	Version int
+	// Mount is the entry of /proc/self/mountinfo used to locate the cgroup directory.
+	Mount CgroupMount
+	// Candidates are the entries of /proc/self/mountinfo considered to locate the cgroup directory.
+	// Mount is the one selected from them.
+	Candidates []CgroupMount
+	// CgroupLine is the line of /proc/self/cgroup used to locate the cgroup directory.
+	CgroupLine string
+	// CgroupPath is the cgroup path from /proc/self/cgroup.
+	CgroupPath string
+	// ResolvedPath is the actual path of the cgroup directory.
+	ResolvedPath string
+	// Resolution is the strategy used to resolve ResolvedPath. See ResolutionRoot, ResolutionMountPoint and ResolutionSearch.
+	Resolution string
+	// Levels are the cgroup directories walked to find the memory limit.
+	// For cgroup v2, it starts from the cgroup directory and walks up to the mountpoint.
+	// For cgroup v1, it only contains the cgroup directory, since the kernel already reports the hierarchical limit.
+	Levels []CgroupLevel
+	// Winner is the index of the most restrictive level in Levels, or -1 if the memory is not limited.
+	Winner int
+	// Limit is the memory limit of the winning level.
+	// If CgroupOptions.IncludeSwap is set, SwapLimit is added to it.
+	Limit uint64
+	// SwapLimit is the swap limit counted in Limit. It is 0 unless CgroupOptions.IncludeSwap is set and the swap is limited.
+	SwapLimit uint64
+}
+
+// CgroupMount is an entry of /proc/self/mountinfo for a cgroup filesystem.
+type CgroupMount struct {
+	// Root is the pathname of the directory in the filesystem which forms the root of this mount.
+	Root string
+	// MountPoint is the pathname of the mount point relative to the process's root directory.
+	MountPoint string
+	// FilesystemType is either "cgroup" or "cgroup2".
+	FilesystemType string
+	// SuperOptions is the per-superblock options.
+	SuperOptions string
+}
+
+// CgroupLevel is a cgroup directory walked to find the memory limit.
+type CgroupLevel struct {
+	// Path is the path of the cgroup directory.
+	Path string
+	// Limit is the memory limit of the directory. It is 0 if the directory is not limited.
+	Limit uint64
+	// Limited reports whether the directory has a memory limit.
+	Limited bool
+	// File is the interface file that the limit was read from, e.g. memory.max.
+	// It is empty if the directory is not limited, or for cgroup v1.
+	File string
+	// Swap is the swap limit of the directory, which is only read if CgroupOptions.IncludeSwap is set.
+	// For cgroup v1, it is memory.memsw.limit_in_bytes minus the memory limit.
+	Swap uint64
+	// SwapLimited reports whether the directory has a swap limit.
+	SwapLimited bool
+}
+
+// setLocation records the located cgroup directory, and resets the result of the previous version if any.
+func (info *CgroupInfo) setLocation(version int, loc cgroupLocation) {
+	info.Version = version
+	info.Mount = newCgroupMount(loc.mount)
+	info.Candidates = make([]CgroupMount, len(loc.candidates))
+	for i, mi := range loc.candidates {
+		info.Candidates[i] = newCgroupMount(mi)
+	}
+	info.CgroupLine = loc.hierarchy.HierarchyID + ":" + loc.hierarchy.ControllerList + ":" + loc.hierarchy.CgroupPath
+	info.CgroupPath = loc.hierarchy.CgroupPath
+	info.ResolvedPath = loc.path
+	info.Resolution = loc.resolution
+	info.Levels = nil
+	info.Winner = -1
+	info.Limit = 0
+	info.SwapLimit = 0
+}
+
+func newCgroupMount(mi mountInfo) CgroupMount {
+	return CgroupMount{
+		Root:           mi.Root,
+		MountPoint:     mi.MountPoint,
+		FilesystemType: mi.FilesystemType,
+		SuperOptions:   mi.SuperOptions,
+	}
+}
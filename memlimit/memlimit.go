@@ -1,19 +1,25 @@
 package memlimit
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 	"math"
 	"os"
+	"path/filepath"
 	"runtime/debug"
 	"strconv"
+	"strings"
 	"time"
 )
 
 const (
 	envGOMEMLIMIT   = "GOMEMLIMIT"
 	envAUTOMEMLIMIT = "AUTOMEMLIMIT"
+	// envAUTOMEMLIMIT_MIN and envAUTOMEMLIMIT_MAX override WithMinLimit and WithMaxLimit.
+	envAUTOMEMLIMIT_MIN = "AUTOMEMLIMIT_MIN"
+	envAUTOMEMLIMIT_MAX = "AUTOMEMLIMIT_MAX"
 	// Deprecated: use memlimit.WithLogger instead
 	envAUTOMEMLIMIT_DEBUG = "AUTOMEMLIMIT_DEBUG"
 
@@ -24,10 +30,28 @@ const (
 var ErrNoLimit = errors.New("memory is not limited")
 
 type config struct {
-	logger   *slog.Logger
-	ratio    float64
-	provider Provider
-	refresh  time.Duration
+	logger       *slog.Logger
+	ratio        float64
+	minReserve   uint64
+	maxReserve   uint64
+	minLimit     uint64
+	maxLimit     uint64
+	adaptive     *AdaptiveHeadroom
+	footprint    func() (uint64, error)
+	psi          func(file string) (psiStats, error)
+	limitFiles   func() ([]string, error)
+	pressure     *PressurePolicy
+	memoryEvents *MemoryEventsPolicy
+	gcPercent    *GCPercentPolicy
+	dryRun       bool
+	provider     Provider
+	ctxProvider  ContextProvider
+	refresh      time.Duration
+	watch        bool
+	restore      bool
+	onChange     []func(Change)
+	changeChans  []chan<- Change
+	onUpdate     []func(Update)
 }
 
 // Option is a function that configures the behavior of SetGoMemLimitWithOptions.
@@ -42,6 +66,120 @@ func WithRatio(ratio float64) Option {
 	}
 }
 
+// WithHeadroomPolicy configures the ratio and the minimum and maximum headroom.
+// The headroom is the part of the memory limit not set as GOMEMLIMIT. See HeadroomPolicy for more details.
+// If Ratio is 0, it defaults to 0.9.
+//
+// Default: HeadroomPolicy{Ratio: 0.9}
+func WithHeadroomPolicy(policy HeadroomPolicy) Option {
+	return func(cfg *config) {
+		policy = policy.withDefaults()
+		cfg.ratio = policy.Ratio
+		cfg.minReserve = policy.MinReserve
+		cfg.maxReserve = policy.MaxReserve
+	}
+}
+
+// WithAdaptiveHeadroom configures automemlimit to determine the headroom from the measured
+// non-Go memory footprint (cgo libraries, mmap, off-heap buffers, ...) instead of the ratio.
+//
+// On every update, it samples the memory usage of the cgroup (memory.current or memory.usage_in_bytes,
+// without the inactive page cache) and the memory mapped by the Go runtime (runtime/metrics), and sets GOMEMLIMIT to
+// limit - nonGoFootprint - Safety, where nonGoFootprint is smoothed to avoid oscillation.
+// It is recommended to use it with WithRefreshInterval, so that GOMEMLIMIT follows the footprint.
+//
+// The ratio and the headroom policy are ignored when it is enabled.
+//
+// Default: disabled
+func WithAdaptiveHeadroom(adaptive AdaptiveHeadroom) Option {
+	return func(cfg *config) {
+		cfg.adaptive = &adaptive
+	}
+}
+
+// WithMinLimit configures the minimum GOMEMLIMIT.
+// If the limit computed from the provider is smaller, it is raised to the minimum and a warning is logged.
+// The minimum applies before WithPressureTightening and WithMemoryEventsWatchdog,
+// so GOMEMLIMIT can be lowered below it while the memory pressure or the memory events cut is in effect.
+// It can be overridden by AUTOMEMLIMIT_MIN environment variable, e.g. AUTOMEMLIMIT_MIN=256MiB.
+//
+// Default: 0 (no minimum)
+func WithMinLimit(min uint64) Option {
+	return func(cfg *config) {
+		cfg.minLimit = min
+	}
+}
+
+// WithMaxLimit configures the maximum GOMEMLIMIT.
+// If the limit computed from the provider is larger, it is lowered to the maximum and a warning is logged.
+// If the memory is not limited, GOMEMLIMIT is set to the maximum.
+// It can be overridden by AUTOMEMLIMIT_MAX environment variable, e.g. AUTOMEMLIMIT_MAX=8GiB.
+//
+// Default: 0 (no maximum)
+func WithMaxLimit(max uint64) Option {
+	return func(cfg *config) {
+		cfg.maxLimit = max
+	}
+}
+
+// WithPressureTightening configures automemlimit to monitor the memory pressure (PSI) of the cgroup v2
+// (memory.pressure, or /proc/pressure/memory as a fallback), and to temporarily lower GOMEMLIMIT
+// toward a floor while the stall percentages exceed the thresholds.
+// GOMEMLIMIT is relaxed back to the limit from the provider when the pressure subsides.
+// See PressurePolicy for more details.
+//
+// The pressure is checked every PressurePolicy.Interval, and also immediately when the PSI trigger
+// of either threshold fires if it can be registered. GOMEMLIMIT moves by one PressurePolicy.Step per check,
+// regardless of how often it is refreshed by the other means.
+//
+// Default: disabled
+func WithPressureTightening(policy PressurePolicy) Option {
+	return func(cfg *config) {
+		policy = policy.withDefaults()
+		cfg.pressure = &policy
+	}
+}
+
+// WithMemoryEventsWatchdog configures automemlimit to watch the counters of the cgroup v2 memory.events
+// (high, max, oom and oom_kill), and to log and report their increases.
+// When the max counter increases, i.e. the memory usage is about to go over memory.max,
+// it can free the OS memory and temporarily lower GOMEMLIMIT. See MemoryEventsPolicy for more details.
+//
+// Default: disabled
+func WithMemoryEventsWatchdog(policy MemoryEventsPolicy) Option {
+	return func(cfg *config) {
+		policy = policy.withDefaults()
+		cfg.memoryEvents = &policy
+	}
+}
+
+// WithGCPercentPolicy configures how GOGC is set alongside GOMEMLIMIT.
+// GOGC is updated whenever GOMEMLIMIT is updated or refreshed, and it is rolled back together with
+// GOMEMLIMIT on panic. With WithRestoreOnStop, the original GOGC is also restored when the Controller stops.
+// See GCPercentPolicy for more details.
+//
+// Default: GCPercentPolicy{Mode: GCPercentUnchanged}
+func WithGCPercentPolicy(policy GCPercentPolicy) Option {
+	return func(cfg *config) {
+		policy = policy.withDefaults()
+		cfg.gcPercent = &policy
+	}
+}
+
+// WithDryRun configures automemlimit to run the provider, the ratio and the refresh as usual,
+// and to log and report the computed GOMEMLIMIT through the callbacks (e.g. WithOnUpdate and WithOnChange),
+// but never to change the runtime: debug.SetMemoryLimit, debug.SetGCPercent and debug.FreeOSMemory are not called.
+// It is also enabled by AUTOMEMLIMIT=dryrun.
+//
+// In the dry run mode, SetGoMemLimitWithOpts and Controller.Limit return the GOMEMLIMIT that would have been set.
+//
+// Default: false
+func WithDryRun() Option {
+	return func(cfg *config) {
+		cfg.dryRun = true
+	}
+}
+
 // WithProvider configures the provider.
 //
 // Default: FromCgroup
@@ -51,6 +189,18 @@ func WithProvider(provider Provider) Option {
 	}
 }
 
+// WithContextProvider configures the ContextProvider to retrieve the memory limit from, instead of the provider.
+// It is called with a context that is cancelled when the Controller stops,
+// and the reported Result is logged at the debug level.
+// Use ApplyTimeout to bound how long it can block, e.g. on a hung FUSE-backed cgroupfs.
+//
+// Default: nil (use the provider)
+func WithContextProvider(cp ContextProvider) Option {
+	return func(cfg *config) {
+		cfg.ctxProvider = cp
+	}
+}
+
 // WithLogger configures the logger.
 // It automatically attaches the "package" attribute to the logs.
 //
@@ -74,6 +224,91 @@ func WithRefreshInterval(refresh time.Duration) Option {
 	}
 }
 
+// WithWatch configures automemlimit to watch the memory limit files with inotify,
+// and to reapply the memory limit from the provider immediately when they are modified.
+// This is useful when the memory limit of the container is resized in place.
+//
+// If no files are given, the cgroup memory limit files of the process are watched
+// (memory.max, memory.high and memory.swap.max for cgroup v2, and memory.limit_in_bytes and
+// memory.memsw.limit_in_bytes for cgroup v1) regardless of the provider.
+// Give the files read by the provider if it is not the default one, e.g. WithWatch(path) with FromFile(path).
+// The given files are watched along with their directories, so that a file replaced by a rename
+// (e.g. in a Kubernetes ConfigMap or Downward API volume) is noticed as well.
+//
+// If watching is not possible, it falls back to polling with the refresh interval,
+// or every minute if WithRefreshInterval is not given.
+// If both WithWatch and WithRefreshInterval are given, the memory limit is refreshed in both ways.
+//
+// Default: false (no watch)
+func WithWatch(files ...string) Option {
+	return func(cfg *config) {
+		cfg.watch = true
+		if len(files) > 0 {
+			cfg.limitFiles = func() ([]string, error) {
+				return watchedFiles(files), nil
+			}
+		}
+	}
+}
+
+// watchedFiles returns the given files followed by their directories.
+func watchedFiles(files []string) []string {
+	watched := append([]string(nil), files...)
+	seen := make(map[string]bool)
+	for _, file := range files {
+		dir := filepath.Dir(file)
+		if !seen[dir] {
+			seen[dir] = true
+			watched = append(watched, dir)
+		}
+	}
+	return watched
+}
+
+// WithRestoreOnStop configures whether to restore the memory limit that was set
+// before Start was called, when the Controller is stopped or its context is done.
+// It has no effect on SetGoMemLimitWithOpts, since it never stops.
+//
+// Default: false
+func WithRestoreOnStop() Option {
+	return func(cfg *config) {
+		cfg.restore = true
+	}
+}
+
+// WithOnChange registers a callback that is called with a Change when GOMEMLIMIT is updated by automemlimit,
+// both by the initial set and by the refresh.
+// The source is one of SourceInit, SourceRefresh, SourceManual, SourceWatch, SourcePressure and SourceMemoryEvents.
+//
+// The callback is called synchronously from the goroutine that updated the limit, in the order of the updates,
+// so it should not block for a long time, and it must not call Controller.Refresh.
+func WithOnChange(fn func(Change)) Option {
+	return func(cfg *config) {
+		cfg.onChange = append(cfg.onChange, fn)
+	}
+}
+
+// WithChangeChannel registers a channel that receives a Change when GOMEMLIMIT is updated by automemlimit.
+// Sending to the channel never blocks; if the channel is not ready, the Change is dropped.
+// Use a buffered channel to avoid missing changes.
+func WithChangeChannel(ch chan<- Change) Option {
+	return func(cfg *config) {
+		cfg.changeChans = append(cfg.changeChans, ch)
+	}
+}
+
+// WithOnUpdate registers a callback that is called after every attempt to update GOMEMLIMIT,
+// whether or not it has changed, and even if the attempt failed.
+// It is intended for collecting metrics. See the memlimit/metrics package.
+//
+// The callback is called synchronously from the goroutine that made the attempt, in the order of the attempts,
+// so it should not block for a long time, and it must not call Controller.Refresh.
+func WithOnUpdate(fn func(Update)) Option {
+	return func(cfg *config) {
+		cfg.onUpdate = append(cfg.onUpdate, fn)
+	}
+}
+
 // WithEnv configures whether to use environment variables.
 //
 // Default: false
@@ -94,23 +329,54 @@ func memlimitLogger(logger *slog.Logger) *slog.Logger {
 //
 // You can configure how much memory of the cgroup's memory limit to set as GOMEMLIMIT
 // through AUTOMEMLIMIT environment variable in the half-open range (0.0,1.0].
+// The minimum and maximum headroom can be appended, e.g. AUTOMEMLIMIT=0.9,min=64Mi,max=1Gi.
+// See HeadroomPolicy for more details.
+//
+// AUTOMEMLIMIT also accepts the following forms with the units of GOMEMLIMIT (B, KiB, MiB, GiB, TiB):
+//   - a percentage, e.g. AUTOMEMLIMIT=85%
+//   - an absolute limit that ignores the provider, e.g. AUTOMEMLIMIT=1.5GiB
+//   - a reservation subtracted from the limit, e.g. AUTOMEMLIMIT=-200MiB
+//
+// As an extension to the GOMEMLIMIT grammar, the short forms Ki, Mi, Gi and Ti are accepted as well.
+// A number without a unit is a ratio, so a ratio outside (0.0,1.0] such as AUTOMEMLIMIT=1073741824 is rejected.
+//
+// GOMEMLIMIT can be clamped with AUTOMEMLIMIT_MIN and AUTOMEMLIMIT_MAX, e.g. AUTOMEMLIMIT_MIN=256MiB.
+// See WithMinLimit and WithMaxLimit.
 //
 // If AUTOMEMLIMIT is not set, it defaults to 0.9. (10% is the headroom for memory sources the Go runtime is unaware of.)
 // If GOMEMLIMIT is already set or AUTOMEMLIMIT=off, this function does nothing.
+// If AUTOMEMLIMIT=dryrun, it computes and logs GOMEMLIMIT without setting it. See WithDryRun.
 //
 // If AUTOMEMLIMIT_EXPERIMENT is set, it enables experimental features.
 // Please see the documentation of Experiments for more details.
 //
+// The refresh goroutine started by this function runs for the lifetime of the process.
+// Use Start if you need to stop it.
+//
 // Options:
 //   - WithRatio
 //   - WithProvider
 //   - WithLogger
-func SetGoMemLimitWithOpts(opts ...Option) (_ int64, _err error) {
+func SetGoMemLimitWithOpts(opts ...Option) (int64, error) {
+	c, err := start(context.Background(), opts...)
+	if err != nil {
+		return 0, err
+	}
+	return int64(c.Limit()), nil
+}
+
+// start sets GOMEMLIMIT in the same way as SetGoMemLimitWithOpts and returns the Controller.
+// The returned Controller is never nil, even if an error is returned.
+func start(ctx context.Context, opts ...Option) (c *Controller, _err error) {
 	// init config
 	cfg := &config{
-		logger:   slog.New(noopLogger{}),
-		ratio:    defaultAUTOMEMLIMIT,
-		provider: FromCgroup,
+		logger:    slog.New(noopLogger{}),
+		ratio:     defaultAUTOMEMLIMIT,
+		footprint: nonGoFootprint,
+		psi:       readMemoryPressure,
+		limitFiles: func() ([]string, error) {
+			return memoryLimitFiles(CgroupOptions{})
+		},
 	}
 	// TODO: remove this
 	if debug, ok := os.LookupEnv(envAUTOMEMLIMIT_DEBUG); ok {
@@ -123,6 +389,9 @@ func SetGoMemLimitWithOpts(opts ...Option) (_ int64, _err error) {
 	for _, opt := range opts {
 		opt(cfg)
 	}
+	if cfg.provider == nil {
+		cfg.provider = fromCgroupWithLogger(cfg.logger)
+	}
 
 	// log error if any on return
 	defer func() {
@@ -131,108 +400,248 @@ func SetGoMemLimitWithOpts(opts ...Option) (_ int64, _err error) {
 		}
 	}()
 
+	// rollback to previous memory limit and GOGC on panic
+	snapshot := debug.SetMemoryLimit(-1)
+	gcSnapshot, _ := readGCPercent()
+	defer rollbackOnPanic(cfg.logger, snapshot, gcSnapshot, &_err)
+
+	// the dry run mode is fixed before the Controller is built, since it can be torn down concurrently.
+	if os.Getenv(envAUTOMEMLIMIT) == "dryrun" {
+		cfg.dryRun = true
+	}
+	c = newController(ctx, cfg, snapshot, gcSnapshot)
+
+	if cfg.ctxProvider != nil {
+		cfg.provider = fromContextProvider(c.ctx, cfg.ctxProvider, cfg.logger)
+	}
+
 	// parse experiments
 	exps, err := parseExperiments()
 	if err != nil {
-		return 0, fmt.Errorf("failed to parse experiments: %w", err)
+		return c, fmt.Errorf("failed to parse experiments: %w", err)
 	}
 	if exps.System {
 		cfg.logger.Info("system experiment is enabled: using system memory limit as a fallback")
 		cfg.provider = ApplyFallback(cfg.provider, FromSystem)
 	}
 
-	// rollback to previous memory limit on panic
-	snapshot := debug.SetMemoryLimit(-1)
-	defer rollbackOnPanic(cfg.logger, snapshot, &_err)
-
 	// check if GOMEMLIMIT is already set
 	if val, ok := os.LookupEnv(envGOMEMLIMIT); ok {
 		cfg.logger.Info("GOMEMLIMIT is already set, skipping", slog.String(envGOMEMLIMIT, val))
-		return 0, nil
+		return c, nil
 	}
 
 	// parse AUTOMEMLIMIT
-	ratio := cfg.ratio
+	policy := HeadroomPolicy{
+		Ratio:      cfg.ratio,
+		MinReserve: cfg.minReserve,
+		MaxReserve: cfg.maxReserve,
+	}
 	if val, ok := os.LookupEnv(envAUTOMEMLIMIT); ok {
 		if val == "off" {
 			cfg.logger.Info("AUTOMEMLIMIT is set to off, skipping")
-			return 0, nil
+			return c, nil
 		}
-		ratio, err = strconv.ParseFloat(val, 64)
-		if err != nil {
-			return 0, fmt.Errorf("cannot parse AUTOMEMLIMIT: %s", val)
+		if val != "dryrun" {
+			var limit uint64
+			policy, limit, err = parseAUTOMEMLIMIT(val, policy)
+			if err != nil {
+				return c, fmt.Errorf("cannot parse AUTOMEMLIMIT: %s", val)
+			}
+			if limit > 0 {
+				cfg.logger.Info("AUTOMEMLIMIT is set to an absolute limit, ignoring the provider", slog.Uint64(envAUTOMEMLIMIT, limit))
+				cfg.provider = Limit(limit)
+			}
+		}
+	}
+	if cfg.dryRun {
+		cfg.logger.Info("dry run is enabled, GOMEMLIMIT will not be changed")
+	}
+
+	// parse AUTOMEMLIMIT_MIN and AUTOMEMLIMIT_MAX
+	if val, ok := os.LookupEnv(envAUTOMEMLIMIT_MIN); ok {
+		if cfg.minLimit, err = parseSize(val); err != nil {
+			return c, fmt.Errorf("cannot parse AUTOMEMLIMIT_MIN: %s", val)
+		}
+	}
+	if val, ok := os.LookupEnv(envAUTOMEMLIMIT_MAX); ok {
+		if cfg.maxLimit, err = parseSize(val); err != nil {
+			return c, fmt.Errorf("cannot parse AUTOMEMLIMIT_MAX: %s", val)
 		}
 	}
 
-	// apply ratio to the provider
-	provider := capProvider(ApplyRatio(cfg.provider, ratio))
+	// apply headroom policy to the provider
+	provider := ApplyHeadroom(c.recordRaw(cfg.provider), policy)
+	c.ratio = policy.Ratio
+	if cfg.adaptive != nil {
+		cfg.logger.Info("adaptive headroom is enabled, ignoring the headroom policy")
+		provider = applyAdaptiveHeadroom(c.recordRaw(cfg.provider), cfg.footprint, *cfg.adaptive, cfg.logger)
+		c.ratio = 0
+	}
+	// the bounds only clamp the limit from the provider, so that the pressure and the memory events cut can go below the minimum.
+	provider = applyBounds(provider, cfg.minLimit, cfg.maxLimit, cfg.logger)
+	factor := newPressureFactor()
+	if cfg.pressure != nil {
+		provider = applyPressure(provider, *cfg.pressure, factor)
+	}
+	cut := &memoryEventsCut{}
+	if cfg.memoryEvents != nil && cfg.memoryEvents.Cut > 0 {
+		provider = applyMemoryEventsCut(provider, cfg.memoryEvents.Cut, cut)
+	}
+	provider = capProvider(provider)
 
 	// set the memory limit and start refresh
-	limit, err := updateGoMemLimit(uint64(snapshot), provider, cfg.logger)
-	refresh(provider, cfg.logger, cfg.refresh)
+	_, err = c.apply(provider, SourceInit)
+	c.run(provider, cfg, factor, cut)
 	if err != nil {
 		if errors.Is(err, ErrNoLimit) {
 			cfg.logger.Info("memory is not limited, skipping")
 			// TODO: consider returning the snapshot
-			return 0, nil
+			return c, nil
 		}
-		return 0, fmt.Errorf("failed to set GOMEMLIMIT: %w", err)
+		return c, fmt.Errorf("failed to set GOMEMLIMIT: %w", err)
 	}
 
-	return int64(limit), nil
+	return c, nil
 }
 
-// updateGoMemLimit updates the Go's memory limit, if it has changed.
-func updateGoMemLimit(currLimit uint64, provider Provider, logger *slog.Logger) (uint64, error) {
-	newLimit, err := provider()
-	if err != nil {
-		return 0, err
+// parseAUTOMEMLIMIT parses the value of AUTOMEMLIMIT environment variable.
+// The value is a comma-separated list of an optional first field and key=value pairs of min and max headroom,
+// e.g. "0.9", "85%", "1.5GiB", "-200MiB", "0.9,min=64Mi,max=1Gi" or "min=256MiB".
+//
+// The first field is one of the following:
+//   - a ratio in the range (0.0,1.0], e.g. "0.9"
+//   - a percentage in the range (0,100], e.g. "85%"
+//   - an absolute limit with a unit, e.g. "1.5GiB"
+//   - a reservation with a unit prefixed with "-", e.g. "-200MiB"
+//
+// A number without a unit is always a ratio, so "1073741824" is rejected rather than taken as bytes;
+// use "1073741824B" or "1GiB" instead.
+// The units are those of GOMEMLIMIT (B, KiB, MiB, GiB, TiB), extended with
+// their short forms (Ki, Mi, Gi, Ti) as in Kubernetes quantities.
+//
+// It returns the absolute limit if given, or 0 otherwise.
+// The fields not in the value are taken from the given policy.
+func parseAUTOMEMLIMIT(val string, policy HeadroomPolicy) (HeadroomPolicy, uint64, error) {
+	var limit uint64
+	for i, field := range strings.Split(val, ",") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			if i != 0 {
+				return HeadroomPolicy{}, 0, fmt.Errorf("ratio must be the first field: %s", field)
+			}
+			var err error
+			policy, limit, err = parseAUTOMEMLIMITFirstField(field, policy)
+			if err != nil {
+				return HeadroomPolicy{}, 0, err
+			}
+			continue
+		}
+
+		size, err := parseSize(value)
+		if err != nil {
+			return HeadroomPolicy{}, 0, err
+		}
+		switch key {
+		case "min":
+			policy.MinReserve = size
+		case "max":
+			policy.MaxReserve = size
+		default:
+			return HeadroomPolicy{}, 0, fmt.Errorf("unknown key: %s", key)
+		}
 	}
 
-	if newLimit == currLimit {
-		logger.Debug("GOMEMLIMIT is not changed, skipping", slog.Uint64(envGOMEMLIMIT, newLimit))
-		return newLimit, nil
+	return policy, limit, nil
+}
+
+// parseAUTOMEMLIMITFirstField parses the first field of AUTOMEMLIMIT environment variable.
+// See parseAUTOMEMLIMIT for the syntax.
+func parseAUTOMEMLIMITFirstField(field string, policy HeadroomPolicy) (HeadroomPolicy, uint64, error) {
+	// ratio
+	if ratio, err := strconv.ParseFloat(field, 64); err == nil {
+		if !(ratio > 0 && ratio <= 1) {
+			return HeadroomPolicy{}, 0, fmt.Errorf("invalid ratio: %s, ratio should be in the range (0.0,1.0], use a unit such as B or MiB for an absolute limit", field)
+		}
+		policy.Ratio = ratio
+		return policy, 0, nil
 	}
 
-	debug.SetMemoryLimit(int64(newLimit))
-	logger.Info("GOMEMLIMIT is updated", slog.Uint64(envGOMEMLIMIT, newLimit), slog.Uint64("previous", currLimit))
+	// percentage
+	if percent, ok := strings.CutSuffix(field, "%"); ok {
+		p, err := strconv.ParseFloat(percent, 64)
+		if err != nil {
+			return HeadroomPolicy{}, 0, err
+		}
+		if !(p > 0 && p <= 100) {
+			return HeadroomPolicy{}, 0, fmt.Errorf("invalid percentage: %s, percentage should be in the range (0,100]", field)
+		}
+		policy.Ratio = p / 100
+		return policy, 0, nil
+	}
 
-	return newLimit, nil
+	// reservation
+	if reservation, ok := strings.CutPrefix(field, "-"); ok {
+		size, err := parseSize(reservation)
+		if err != nil {
+			return HeadroomPolicy{}, 0, err
+		}
+		return HeadroomPolicy{Ratio: 1, MinReserve: size}, 0, nil
+	}
+
+	// absolute limit
+	limit, err := parseSize(field)
+	if err != nil {
+		return HeadroomPolicy{}, 0, err
+	}
+	if limit == 0 {
+		return HeadroomPolicy{}, 0, errors.New("absolute limit must be greater than 0")
+	}
+	return HeadroomPolicy{Ratio: 1}, limit, nil
 }
 
-// refresh spawns a goroutine that runs every refresh duration and updates the GOMEMLIMIT if it has changed.
-// See more details in the documentation of WithRefreshInterval.
-func refresh(provider Provider, logger *slog.Logger, refresh time.Duration) {
-	if refresh == 0 {
-		return
+// updateGoMemLimit updates the Go's memory limit, if it has changed.
+// It also updates GOGC by the given setter, which can be nil.
+// If dryRun is true, it only logs the limit that would have been set.
+func updateGoMemLimit(currLimit uint64, provider Provider, gc *gcPercentSetter, dryRun bool, logger *slog.Logger) (uint64, error) {
+	newLimit, err := provider()
+	if err != nil {
+		return 0, err
+	}
+
+	// GOGC can change even if the limit has not changed, e.g. by the live heap.
+	gcUpdate, gcChanged, err := gc.next(newLimit, dryRun)
+	if err != nil {
+		return 0, err
 	}
 
-	provider = noErrNoLimitProvider(provider)
+	// the tighter change is applied first, so that the GC is always bounded by either GOGC or GOMEMLIMIT,
+	// e.g. GOGC is turned off only after GOMEMLIMIT is set, and restored before GOMEMLIMIT is lifted.
+	limitFirst := newLimit < currLimit || gcUpdate.percent < 0
+	if gcChanged && !limitFirst {
+		gc.apply(gcUpdate, dryRun, logger)
+	}
 
-	t := time.NewTicker(refresh)
-	go func() {
-		for range t.C {
-			err := func() (_err error) {
-				snapshot := debug.SetMemoryLimit(-1)
-				defer rollbackOnPanic(logger, snapshot, &_err)
+	switch {
+	case newLimit == currLimit:
+		logger.Debug("GOMEMLIMIT is not changed, skipping", slog.Uint64(envGOMEMLIMIT, newLimit))
+	case dryRun:
+		logger.Info("GOMEMLIMIT would be updated (dry run)", slog.Uint64(envGOMEMLIMIT, newLimit), slog.Uint64("previous", currLimit))
+	default:
+		debug.SetMemoryLimit(int64(newLimit))
+		logger.Info("GOMEMLIMIT is updated", slog.Uint64(envGOMEMLIMIT, newLimit), slog.Uint64("previous", currLimit))
+	}
 
-				_, err := updateGoMemLimit(uint64(snapshot), provider, logger)
-				if err != nil {
-					return err
-				}
+	if gcChanged && limitFirst {
+		gc.apply(gcUpdate, dryRun, logger)
+	}
 
-				return nil
-			}()
-			if err != nil {
-				logger.Error("failed to refresh GOMEMLIMIT", slog.Any("error", err))
-			}
-		}
-	}()
+	return newLimit, nil
 }
 
-// rollbackOnPanic rollbacks to the snapshot on panic.
+// rollbackOnPanic rollbacks to the snapshots of the memory limit and GOGC on panic.
 // Since it uses recover, it should be called in a deferred function.
-func rollbackOnPanic(logger *slog.Logger, snapshot int64, err *error) {
+func rollbackOnPanic(logger *slog.Logger, snapshot int64, gcSnapshot int, err *error) {
 	panicErr := recover()
 	if panicErr != nil {
 		if *err != nil {
@@ -242,6 +651,9 @@ func rollbackOnPanic(logger *slog.Logger, snapshot int64, err *error) {
 			snapshot, panicErr,
 		)
 		debug.SetMemoryLimit(snapshot)
+		if curr, _ := readGCPercent(); curr != gcSnapshot {
+			debug.SetGCPercent(gcSnapshot)
+		}
 	}
 }
 
@@ -4,11 +4,17 @@
 package memlimit
 
 import (
+	"context"
 	"flag"
 	"math"
 	"os"
+	"path/filepath"
+	"runtime"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 var (
@@ -231,3 +237,98 @@ func TestSetGoMemLimitWithProvider_WithSystemProvider(t *testing.T) {
 		})
 	}
 }
+
+func TestStart_WithWatch_Modified(t *testing.T) {
+	t.Cleanup(func() {
+		debug.SetMemoryLimit(math.MaxInt64)
+	})
+
+	file := filepath.Join(t.TempDir(), "memory.max")
+	if err := os.WriteFile(file, []byte("123456789\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := make(chan Change, 8)
+	c, err := Start(context.Background(),
+		WithProvider(FromFile(file)),
+		WithRatio(1),
+		WithWatch(file),
+		WithChangeChannel(changes),
+	)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer c.Stop()
+	if change := <-changes; change.New != 123456789 || change.Source != SourceInit {
+		t.Fatalf("change got = %+v, want New = %v, Source = %v", change, 123456789, SourceInit)
+	}
+
+	if err := os.WriteFile(file, []byte("987654321\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// the file is truncated before it is written, but the empty file does not lift the limit.
+	select {
+	case change := <-changes:
+		if change.New != 987654321 || change.Source != SourceWatch {
+			t.Errorf("change got = %+v, want New = %v, Source = %v", change, 987654321, SourceWatch)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no Change from the watch after modifying the limit file")
+	}
+
+	// replacing the file by a rename is noticed by watching the directory,
+	// even after the watch of the replaced file is gone.
+	for _, want := range []uint64{555555555, 444444444} {
+		tmp := filepath.Join(t.TempDir(), "memory.max")
+		if err := os.WriteFile(tmp, []byte(strconv.FormatUint(want, 10)+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, file); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case change := <-changes:
+			if change.New != want || change.Source != SourceWatch {
+				t.Errorf("change got = %+v, want New = %v, Source = %v", change, want, SourceWatch)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no Change from the watch after replacing the limit file with %v", want)
+		}
+	}
+}
+
+func TestStart_StopWaitsForGoroutines(t *testing.T) {
+	t.Cleanup(func() {
+		debug.SetMemoryLimit(math.MaxInt64)
+	})
+
+	file := filepath.Join(t.TempDir(), "memory.max")
+	if err := os.WriteFile(file, []byte("123456789\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Start(context.Background(),
+		WithProvider(FromFile(file)),
+		WithRatio(1),
+		WithWatch(file),
+		WithPressureTightening(PressurePolicy{}),
+		WithMemoryEventsWatchdog(MemoryEventsPolicy{}),
+	)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	start := time.Now()
+	c.Stop()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Stop() took %v", elapsed)
+	}
+	// the watcher, the pressure monitor and the PSI trigger goroutines have exited when Stop returns.
+	buf := make([]byte, 1<<20)
+	stacks := string(buf[:runtime.Stack(buf, true)])
+	for _, fn := range []string{"watchMemoryLimit", "monitorMemoryPressure", "watchMemoryPressure", "watchMemoryEvents"} {
+		if strings.Contains(stacks, "created by github.com/KimMachineGun/automemlimit/memlimit."+fn) {
+			t.Errorf("goroutine created by %s is running after Stop()", fn)
+		}
+	}
+}
@@ -297,3 +297,240 @@ func TestSetGoMemLimitWithOpts_WithRefreshInterval(t *testing.T) {
 		t.Errorf("debug.SetMemoryLimit(-1) got = %v, want %v", curr, math.MaxInt32)
 	}
 }
+
+func TestParseAUTOMEMLIMIT(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		want      HeadroomPolicy
+		wantLimit uint64
+		wantErr   bool
+	}{
+		{
+			name:  "ratio",
+			input: "0.8",
+			want:  HeadroomPolicy{Ratio: 0.8},
+		},
+		{
+			name:  "ratio with min and max",
+			input: "0.9,min=64Mi,max=1Gi",
+			want:  HeadroomPolicy{Ratio: 0.9, MinReserve: 64 << 20, MaxReserve: 1 << 30},
+		},
+		{
+			name:  "min only",
+			input: "min=256MiB",
+			want:  HeadroomPolicy{Ratio: defaultAUTOMEMLIMIT, MinReserve: 256 << 20},
+		},
+		{
+			name:  "percentage",
+			input: "85%",
+			want:  HeadroomPolicy{Ratio: 0.85},
+		},
+		{
+			name:      "absolute",
+			input:     "1.5GiB",
+			want:      HeadroomPolicy{Ratio: 1},
+			wantLimit: 3 << 29,
+		},
+		{
+			name:  "reservation",
+			input: "-200MiB",
+			want:  HeadroomPolicy{Ratio: 1, MinReserve: 200 << 20},
+		},
+		{
+			name:    "ratio not first",
+			input:   "min=64Mi,0.9",
+			wantErr: true,
+		},
+		{
+			name:    "unknown key",
+			input:   "0.9,foo=1Gi",
+			wantErr: true,
+		},
+		{
+			name:    "invalid size",
+			input:   "0.9,min=abc",
+			wantErr: true,
+		},
+		{
+			name:    "invalid percentage",
+			input:   "abc%",
+			wantErr: true,
+		},
+		{
+			name:  "ratio of 1",
+			input: "1",
+			want:  HeadroomPolicy{Ratio: 1},
+		},
+		{
+			name:    "bytes without unit",
+			input:   "1073741824",
+			wantErr: true,
+		},
+		{
+			name:    "zero ratio",
+			input:   "0",
+			wantErr: true,
+		},
+		{
+			name:    "percentage over 100",
+			input:   "150%",
+			wantErr: true,
+		},
+		{
+			name:      "bytes",
+			input:     "1073741824B",
+			want:      HeadroomPolicy{Ratio: 1},
+			wantLimit: 1 << 30,
+		},
+		{
+			name:    "zero absolute",
+			input:   "0GiB",
+			wantErr: true,
+		},
+		{
+			name:    "unknown unit",
+			input:   "1.5GB",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotLimit, err := parseAUTOMEMLIMIT(tt.input, HeadroomPolicy{Ratio: defaultAUTOMEMLIMIT})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseAUTOMEMLIMIT() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseAUTOMEMLIMIT() got = %+v, want %+v", got, tt.want)
+			}
+			if gotLimit != tt.wantLimit {
+				t.Errorf("parseAUTOMEMLIMIT() limit = %v, want %v", gotLimit, tt.wantLimit)
+			}
+		})
+	}
+}
+
+func TestSetGoMemLimitWithOpts_AUTOMEMLIMIT(t *testing.T) {
+	tests := []struct {
+		name       string
+		env        string
+		gomemlimit int64
+	}{
+		{name: "ratio", env: "0.5", gomemlimit: 512 << 20},
+		{name: "percentage", env: "25%", gomemlimit: 256 << 20},
+		{name: "absolute", env: "100MiB", gomemlimit: 100 << 20},
+		{name: "reservation", env: "-200MiB", gomemlimit: 824 << 20},
+		{name: "ratio with max", env: "0.5,max=100MiB", gomemlimit: 924 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(func() {
+				debug.SetMemoryLimit(math.MaxInt64)
+			})
+			t.Setenv(envAUTOMEMLIMIT, tt.env)
+
+			got, err := SetGoMemLimitWithOpts(WithProvider(Limit(1 << 30)))
+			if err != nil {
+				t.Fatalf("SetGoMemLimitWithOpts() error = %v", err)
+			}
+			if got != tt.gomemlimit {
+				t.Errorf("SetGoMemLimitWithOpts() got = %v, want %v", got, tt.gomemlimit)
+			}
+			if curr := debug.SetMemoryLimit(-1); curr != tt.gomemlimit {
+				t.Errorf("debug.SetMemoryLimit(-1) got = %v, want %v", curr, tt.gomemlimit)
+			}
+		})
+	}
+}
+
+func TestSetGoMemLimitWithOpts_Bounds(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		env        map[string]string
+		gomemlimit int64
+	}{
+		{name: "within bounds", opts: []Option{WithMinLimit(64 << 20), WithMaxLimit(2 << 30)}, gomemlimit: 1 << 30},
+		{name: "min", opts: []Option{WithMinLimit(2 << 30)}, gomemlimit: 2 << 30},
+		{name: "max", opts: []Option{WithMaxLimit(512 << 20)}, gomemlimit: 512 << 20},
+		{name: "env overrides option", opts: []Option{WithMaxLimit(512 << 20)}, env: map[string]string{envAUTOMEMLIMIT_MAX: "256MiB"}, gomemlimit: 256 << 20},
+		{name: "env min", env: map[string]string{envAUTOMEMLIMIT_MIN: "2GiB"}, gomemlimit: 2 << 30},
+		{
+			name:       "env max without limit",
+			opts:       []Option{WithProvider(func() (uint64, error) { return 0, ErrNoLimit })},
+			env:        map[string]string{envAUTOMEMLIMIT_MAX: "512MiB"},
+			gomemlimit: 512 << 20,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(func() {
+				debug.SetMemoryLimit(math.MaxInt64)
+			})
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			got, err := SetGoMemLimitWithOpts(append([]Option{WithProvider(Limit(1 << 30)), WithRatio(1)}, tt.opts...)...)
+			if err != nil {
+				t.Fatalf("SetGoMemLimitWithOpts() error = %v", err)
+			}
+			if got != tt.gomemlimit {
+				t.Errorf("SetGoMemLimitWithOpts() got = %v, want %v", got, tt.gomemlimit)
+			}
+		})
+	}
+}
+
+func TestSetGoMemLimitWithOpts_DryRun(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		env  string
+	}{
+		{name: "WithDryRun", opts: []Option{WithDryRun()}},
+		{name: "AUTOMEMLIMIT=dryrun", env: "dryrun"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(func() {
+				debug.SetMemoryLimit(math.MaxInt64)
+				debug.SetGCPercent(100)
+			})
+			if tt.env != "" {
+				t.Setenv(envAUTOMEMLIMIT, tt.env)
+			}
+
+			var updates []Update
+			got, err := SetGoMemLimitWithOpts(append([]Option{
+				WithProvider(Limit(1 << 30)),
+				WithGCPercentPolicy(GCPercentPolicy{Mode: GCPercentOffWhenLimited}),
+				WithOnUpdate(func(u Update) {
+					updates = append(updates, u)
+				}),
+			}, tt.opts...)...)
+			if err != nil {
+				t.Fatalf("SetGoMemLimitWithOpts() error = %v", err)
+			}
+			if want := int64(966367641); got != want {
+				t.Errorf("SetGoMemLimitWithOpts() got = %v, want %v", got, want)
+			}
+
+			// the runtime is not changed
+			if curr := debug.SetMemoryLimit(-1); curr != math.MaxInt64 {
+				t.Errorf("debug.SetMemoryLimit(-1) got = %v, want %v", curr, int64(math.MaxInt64))
+			}
+			if curr, _ := readGCPercent(); curr != 100 {
+				t.Errorf("GOGC got = %v, want %v", curr, 100)
+			}
+
+			if len(updates) != 1 {
+				t.Fatalf("updates got = %v, want 1 update", updates)
+			}
+			if u := updates[0]; !u.DryRun || u.Limit != uint64(got) || u.Previous != math.MaxInt64 {
+				t.Errorf("updates[0] got = %+v, want DryRun with Limit %v and Previous %v", u, got, int64(math.MaxInt64))
+			}
+		})
+	}
+}
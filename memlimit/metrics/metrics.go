@@ -0,0 +1,167 @@
+// Package metrics collects the state of automemlimit and exposes it
+// in the Prometheus text exposition format, without depending on the Prometheus client library.
+//
+//	c := metrics.NewCollector()
+//	memlimit.SetGoMemLimitWithOpts(
+//		c.Option(),
+//		memlimit.WithRefreshInterval(1*time.Minute),
+//	)
+//	http.Handle("/metrics/automemlimit", c)
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KimMachineGun/automemlimit/memlimit"
+)
+
+// ContentType is the content type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Kinds of the errors reported by automemlimit_refresh_errors_total.
+const (
+	KindNoLimit      = "no_limit"
+	KindNoCgroup     = "no_cgroup"
+	KindNotSupported = "not_supported"
+	KindOther        = "other"
+)
+
+// Collector collects the state of automemlimit from memlimit.Update.
+// It is safe for concurrent use.
+type Collector struct {
+	mu             sync.Mutex
+	containerLimit uint64
+	limit          uint64
+	ratio          float64
+	refreshes      map[string]uint64
+	errors         map[string]uint64
+	lastChange     time.Time
+}
+
+// NewCollector returns a new Collector.
+func NewCollector() *Collector {
+	return &Collector{
+		refreshes: make(map[string]uint64),
+		errors:    make(map[string]uint64),
+	}
+}
+
+// Option returns a memlimit.Option that reports the updates to the Collector.
+func (c *Collector) Option() memlimit.Option {
+	return memlimit.WithOnUpdate(c.Observe)
+}
+
+// Observe records the given update.
+func (c *Collector) Observe(u memlimit.Update) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.refreshes[u.Source]++
+	c.ratio = u.Ratio
+	c.limit = u.Limit
+	if u.Err != nil {
+		c.errors[ErrorKind(u.Err)]++
+		return
+	}
+
+	c.containerLimit = u.Raw
+	if u.Limit != u.Previous {
+		c.lastChange = u.Time
+	}
+}
+
+// ErrorKind classifies the error reported by automemlimit into one of the kinds, e.g. KindNoCgroup.
+func ErrorKind(err error) string {
+	switch {
+	case errors.Is(err, memlimit.ErrNoLimit):
+		return KindNoLimit
+	case errors.Is(err, memlimit.ErrNoCgroup):
+		return KindNoCgroup
+	case errors.Is(err, memlimit.ErrCgroupsNotSupported):
+		return KindNotSupported
+	default:
+		return KindOther
+	}
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format to w.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	c.mu.Lock()
+	var b strings.Builder
+	writeMetric(&b, "automemlimit_container_limit_bytes", "gauge",
+		"Memory limit returned by the provider before the ratio was applied. 0 if not limited.",
+		sample{value: float64(c.containerLimit)},
+	)
+	writeMetric(&b, "automemlimit_gomemlimit_bytes", "gauge",
+		"GOMEMLIMIT after the last update.",
+		sample{value: float64(c.limit)},
+	)
+	writeMetric(&b, "automemlimit_ratio", "gauge",
+		"Ratio of the memory limit to set as GOMEMLIMIT.",
+		sample{value: c.ratio},
+	)
+	writeMetric(&b, "automemlimit_refreshes_total", "counter",
+		"Number of attempts to update GOMEMLIMIT.",
+		labeled("source", c.refreshes)...,
+	)
+	writeMetric(&b, "automemlimit_refresh_errors_total", "counter",
+		"Number of failed attempts to update GOMEMLIMIT.",
+		labeled("kind", c.errors)...,
+	)
+	var lastChange float64
+	if !c.lastChange.IsZero() {
+		lastChange = float64(c.lastChange.UnixMilli()) / 1000
+	}
+	writeMetric(&b, "automemlimit_last_change_timestamp_seconds", "gauge",
+		"Unix time of the last change of GOMEMLIMIT. 0 if never changed.",
+		sample{value: lastChange},
+	)
+	c.mu.Unlock()
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	_, _ = c.WriteTo(w)
+}
+
+type sample struct {
+	labels string
+	value  float64
+}
+
+// labeled returns the samples for the given counters, sorted by the label value.
+func labeled(name string, counters map[string]uint64) []sample {
+	keys := make([]string, 0, len(counters))
+	for k := range counters {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	samples := make([]sample, 0, len(keys))
+	for _, k := range keys {
+		samples = append(samples, sample{
+			labels: fmt.Sprintf("{%s=%q}", name, k),
+			value:  float64(counters[k]),
+		})
+	}
+	return samples
+}
+
+func writeMetric(b *strings.Builder, name, typ, help string, samples ...sample) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, typ)
+	for _, s := range samples {
+		fmt.Fprintf(b, "%s%s %v\n", name, s.labels, s.value)
+	}
+}
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"runtime/debug"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/KimMachineGun/automemlimit/memlimit"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+	now := time.Unix(1700000000, 500*int64(time.Millisecond))
+	c.Observe(memlimit.Update{
+		Source:   memlimit.SourceInit,
+		Raw:      1 << 30,
+		Limit:    966367641,
+		Previous: math.MaxInt64,
+		Ratio:    0.9,
+		Time:     now,
+	})
+	c.Observe(memlimit.Update{
+		Source:   memlimit.SourceRefresh,
+		Limit:    966367641,
+		Previous: 966367641,
+		Ratio:    0.9,
+		Err:      fmt.Errorf("wrapped: %w", memlimit.ErrNoCgroup),
+		Time:     now.Add(time.Minute),
+	})
+	c.Observe(memlimit.Update{
+		Source:   memlimit.SourceRefresh,
+		Raw:      1 << 30,
+		Limit:    966367641,
+		Previous: 966367641,
+		Ratio:    0.9,
+		Time:     now.Add(2 * time.Minute),
+	})
+
+	rec := httptest.NewRecorder()
+	c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if got := rec.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("Content-Type got = %v, want %v", got, ContentType)
+	}
+
+	want := `# HELP automemlimit_container_limit_bytes Memory limit returned by the provider before the ratio was applied. 0 if not limited.
+# TYPE automemlimit_container_limit_bytes gauge
+automemlimit_container_limit_bytes 1.073741824e+09
+# HELP automemlimit_gomemlimit_bytes GOMEMLIMIT after the last update.
+# TYPE automemlimit_gomemlimit_bytes gauge
+automemlimit_gomemlimit_bytes 9.66367641e+08
+# HELP automemlimit_ratio Ratio of the memory limit to set as GOMEMLIMIT.
+# TYPE automemlimit_ratio gauge
+automemlimit_ratio 0.9
+# HELP automemlimit_refreshes_total Number of attempts to update GOMEMLIMIT.
+# TYPE automemlimit_refreshes_total counter
+automemlimit_refreshes_total{source="init"} 1
+automemlimit_refreshes_total{source="refresh"} 2
+# HELP automemlimit_refresh_errors_total Number of failed attempts to update GOMEMLIMIT.
+# TYPE automemlimit_refresh_errors_total counter
+automemlimit_refresh_errors_total{kind="no_cgroup"} 1
+# HELP automemlimit_last_change_timestamp_seconds Unix time of the last change of GOMEMLIMIT. 0 if never changed.
+# TYPE automemlimit_last_change_timestamp_seconds gauge
+automemlimit_last_change_timestamp_seconds 1.7000000005e+09
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body got =\n%s\nwant =\n%s", got, want)
+	}
+}
+
+func TestCollector_Option(t *testing.T) {
+	t.Cleanup(func() {
+		debug.SetMemoryLimit(math.MaxInt64)
+	})
+
+	c := NewCollector()
+	_, err := memlimit.SetGoMemLimitWithOpts(
+		c.Option(),
+		memlimit.WithProvider(memlimit.Limit(1<<30)),
+		memlimit.WithRatio(0.5),
+	)
+	if err != nil {
+		t.Fatalf("SetGoMemLimitWithOpts() error = %v", err)
+	}
+
+	var b strings.Builder
+	if _, err := c.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	for _, want := range []string{
+		"automemlimit_container_limit_bytes 1.073741824e+09\n",
+		"automemlimit_gomemlimit_bytes 5.36870912e+08\n",
+		"automemlimit_ratio 0.5\n",
+		`automemlimit_refreshes_total{source="init"} 1` + "\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("WriteTo() got =\n%s\nwant to contain %q", b.String(), want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package memlimit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PressurePolicy configures how GOMEMLIMIT is tightened under memory pressure. See WithPressureTightening.
+// The zero values are replaced with the defaults.
+type PressurePolicy struct {
+	// SomeThreshold is the share of time in percent, over the last 10 seconds, in which at least one task
+	// was stalled on memory (the "some avg10" of PSI), above which GOMEMLIMIT is tightened.
+	//
+	// Default: 10
+	SomeThreshold float64
+	// FullThreshold is the share of time in percent, over the last 10 seconds, in which all tasks
+	// were stalled on memory (the "full avg10" of PSI), above which GOMEMLIMIT is tightened.
+	//
+	// Default: 5
+	FullThreshold float64
+	// Floor is the ratio of the limit from the provider that GOMEMLIMIT can be tightened down to,
+	// in the half-open range (0.0,1.0].
+	//
+	// Default: 0.7
+	Floor float64
+	// Step is the ratio of the limit from the provider by which GOMEMLIMIT is tightened or relaxed at each check.
+	//
+	// Default: 0.05
+	Step float64
+	// Interval is how often the memory pressure is checked.
+	//
+	// Default: 5s
+	Interval time.Duration
+}
+
+// withDefaults returns the policy with the default values for the unset fields.
+func (p PressurePolicy) withDefaults() PressurePolicy {
+	if p.SomeThreshold == 0 {
+		p.SomeThreshold = 10
+	}
+	if p.FullThreshold == 0 {
+		p.FullThreshold = 5
+	}
+	if p.Floor == 0 {
+		p.Floor = 0.7
+	}
+	if p.Step == 0 {
+		p.Step = 0.05
+	}
+	if p.Interval == 0 {
+		p.Interval = 5 * time.Second
+	}
+	return p
+}
+
+// psiStats is the memory pressure stall information.
+// See https://docs.kernel.org/accounting/psi.html
+type psiStats struct {
+	// SomeAvg10 is the share of time in percent in which at least one task was stalled, over the last 10 seconds.
+	SomeAvg10 float64
+	// FullAvg10 is the share of time in percent in which all tasks were stalled, over the last 10 seconds.
+	FullAvg10 float64
+}
+
+// parsePressure parses the content of memory.pressure or /proc/pressure/memory.
+//
+//	some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+//	full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func parsePressure(b []byte) (psiStats, error) {
+	var (
+		stats psiStats
+		s     = bufio.NewScanner(bytes.NewReader(b))
+	)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		var avg10 *float64
+		switch fields[0] {
+		case "some":
+			avg10 = &stats.SomeAvg10
+		case "full":
+			avg10 = &stats.FullAvg10
+		default:
+			return psiStats{}, fmt.Errorf("failed to parse pressure %q: unknown kind", s.Text())
+		}
+		for _, field := range fields[1:] {
+			if v, ok := strings.CutPrefix(field, "avg10="); ok {
+				f, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					return psiStats{}, fmt.Errorf("failed to parse pressure %q: %w", s.Text(), err)
+				}
+				*avg10 = f
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return psiStats{}, err
+	}
+
+	return stats, nil
+}
+
+// memoryPressureFile returns the file that reports the memory pressure of the process,
+// which is memory.pressure of the cgroup v2 directory, or /proc/pressure/memory as a fallback.
+func memoryPressureFile(opts CgroupOptions) string {
+	opts = opts.withDefaults()
+	if mis, chs, err := readCgroupProcFiles(opts); err == nil {
+		if _, v2 := detectCgroupVersion(mis); v2 {
+			if loc, err := locateCgroupV2(opts, chs, mis); err == nil {
+				file := filepath.Join(loc.path, "memory.pressure")
+				if _, err := fs.Stat(opts.FS, fsPath(file)); err == nil {
+					return file
+				}
+			}
+		}
+	}
+	return "/proc/pressure/memory"
+}
+
+// readMemoryPressure reads the memory pressure from the file returned by memoryPressureFile.
+func readMemoryPressure(file string) (psiStats, error) {
+	b, err := CgroupOptions{}.withDefaults().readFile(file)
+	if err != nil {
+		return psiStats{}, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	return parsePressure(b)
+}
+
+// pressureFactor is the ratio of the limit from the provider kept as GOMEMLIMIT under memory pressure.
+// It is stepped only by the pressure monitor, once per check, and the provider only reads it.
+type pressureFactor struct {
+	mu     sync.Mutex
+	factor float64
+}
+
+func newPressureFactor() *pressureFactor {
+	return &pressureFactor{factor: 1}
+}
+
+func (f *pressureFactor) get() float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.factor
+}
+
+// step tightens the factor by policy.Step while the memory pressure exceeds the thresholds, down to policy.Floor,
+// and relaxes it back by policy.Step while the pressure is below the thresholds.
+// It reports whether the factor has changed.
+func (f *pressureFactor) step(policy PressurePolicy, stats psiStats) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	prev := f.factor
+	if stats.SomeAvg10 > policy.SomeThreshold || stats.FullAvg10 > policy.FullThreshold {
+		f.factor = max(f.factor-policy.Step, policy.Floor)
+	} else {
+		f.factor = min(f.factor+policy.Step, 1)
+	}
+	return f.factor != prev
+}
+
+// applyPressure returns a Provider that multiplies the limit of the given provider by the pressure factor.
+func applyPressure(provider Provider, policy PressurePolicy, factor *pressureFactor) Provider {
+	return func() (uint64, error) {
+		if policy.Floor <= 0 || policy.Floor > 1 {
+			return 0, fmt.Errorf("invalid pressure floor: %f, floor should be in the range (0.0,1.0]", policy.Floor)
+		}
+		limit, err := provider()
+		if err != nil {
+			return 0, err
+		}
+		return uint64(float64(limit) * factor.get()), nil
+	}
+}
+
+// monitorMemoryPressure checks the memory pressure of the given file every policy.Interval,
+// and also when a PSI trigger fires if it can be registered, and steps the factor by the result.
+// If the pressure cannot be read, it is treated as no pressure.
+// It sends to the returned channel when the factor has changed, so that GOMEMLIMIT is reapplied.
+// The channel is closed when the context is done.
+// The goroutines checking the pressure are added to wg.
+func monitorMemoryPressure(ctx context.Context, wg *sync.WaitGroup, file string, policy PressurePolicy, factor *pressureFactor, pressure func(file string) (psiStats, error), logger *slog.Logger) <-chan struct{} {
+	triggers, err := watchMemoryPressure(ctx, wg, file, policy, logger)
+	if err != nil {
+		if !errors.Is(err, ErrCgroupsNotSupported) {
+			logger.Debug("failed to register PSI trigger, polling memory pressure", slog.Any("error", err))
+		}
+		triggers = nil
+	}
+
+	ch := make(chan struct{}, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(ch)
+
+		t := time.NewTicker(policy.Interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			case _, ok := <-triggers:
+				if !ok {
+					triggers = nil
+					continue
+				}
+			}
+
+			stats, err := pressure(file)
+			if err != nil {
+				logger.Debug("failed to read memory pressure", slog.Any("error", err))
+				stats = psiStats{}
+			}
+			if !factor.step(policy, stats) {
+				continue
+			}
+			logger.Info("memory pressure changed, adjusting GOMEMLIMIT",
+				slog.Float64("some_avg10", stats.SomeAvg10),
+				slog.Float64("full_avg10", stats.FullAvg10),
+				slog.Float64("factor", factor.get()),
+			)
+
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return ch
+}
@@ -0,0 +1,136 @@
+//go:build linux
+// +build linux
+
+package memlimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// psiTriggerWindow is the time window of the PSI trigger.
+// Unprivileged users can only register triggers with a window that is a multiple of 2 seconds.
+const psiTriggerWindow = 2 * time.Second
+
+// watchMemoryPressure registers PSI triggers for the "some" and "full" thresholds of the policy
+// on the given memory pressure file. It sends to the returned channel when either trigger fires.
+// The channel is closed when the context is done or watching fails.
+// The goroutine waiting for the triggers is added to wg.
+func watchMemoryPressure(ctx context.Context, wg *sync.WaitGroup, file string, policy PressurePolicy, logger *slog.Logger) (<-chan struct{}, error) {
+	// a file descriptor can hold only one trigger, so the file is opened for each of them.
+	someFd, err := registerPSITrigger(file, "some", policy.SomeThreshold, logger)
+	if err != nil {
+		return nil, err
+	}
+	fullFd, err := registerPSITrigger(file, "full", policy.FullThreshold, logger)
+	if err != nil {
+		syscall.Close(someFd)
+		return nil, err
+	}
+
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		syscall.Close(fullFd)
+		syscall.Close(someFd)
+		return nil, fmt.Errorf("failed to create epoll: %w", err)
+	}
+	for _, fd := range []int{someFd, fullFd} {
+		event := syscall.EpollEvent{Events: syscall.EPOLLPRI, Fd: int32(fd)}
+		if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, fd, &event); err != nil {
+			syscall.Close(epfd)
+			syscall.Close(fullFd)
+			syscall.Close(someFd)
+			return nil, fmt.Errorf("failed to poll %s: %w", file, err)
+		}
+	}
+
+	// the read end of the pipe becomes readable (EOF) when the write end is closed on the context done,
+	// which wakes up the pending epoll_wait.
+	var wake [2]int
+	if err := syscall.Pipe2(wake[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK); err != nil {
+		syscall.Close(epfd)
+		syscall.Close(fullFd)
+		syscall.Close(someFd)
+		return nil, fmt.Errorf("failed to create pipe: %w", err)
+	}
+	event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(wake[0])}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, wake[0], &event); err != nil {
+		syscall.Close(wake[0])
+		syscall.Close(wake[1])
+		syscall.Close(epfd)
+		syscall.Close(fullFd)
+		syscall.Close(someFd)
+		return nil, fmt.Errorf("failed to poll pipe: %w", err)
+	}
+
+	ch := make(chan struct{}, 1)
+	stop := context.AfterFunc(ctx, func() {
+		syscall.Close(wake[1])
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(ch)
+		defer syscall.Close(epfd)
+		defer syscall.Close(someFd)
+		defer syscall.Close(fullFd)
+		defer syscall.Close(wake[0])
+		defer func() {
+			if stop() {
+				syscall.Close(wake[1])
+			}
+		}()
+
+		events := make([]syscall.EpollEvent, 3)
+		for {
+			n, err := syscall.EpollWait(epfd, events, -1)
+			if err != nil {
+				if err == syscall.EINTR {
+					continue
+				}
+				logger.Error("failed to wait for PSI trigger", slog.Any("error", err))
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			for _, event := range events[:n] {
+				if event.Fd != int32(wake[0]) && event.Events&syscall.EPOLLERR != 0 {
+					logger.Error("PSI trigger is no longer available", slog.String("file", file))
+					return
+				}
+			}
+
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// registerPSITrigger opens the memory pressure file and registers a PSI trigger of the given kind ("some" or "full"),
+// which fires when tasks are stalled longer than the threshold within the window.
+// It returns the file descriptor to poll for the trigger.
+func registerPSITrigger(file, kind string, threshold float64, logger *slog.Logger) (int, error) {
+	fd, err := syscall.Open(file, syscall.O_RDWR|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, fmt.Errorf("failed to open %s: %w", file, err)
+	}
+
+	stall := time.Duration(float64(psiTriggerWindow) * threshold / 100)
+	trigger := fmt.Sprintf("%s %d %d", kind, stall.Microseconds(), psiTriggerWindow.Microseconds())
+	if _, err := syscall.Write(fd, []byte(trigger+"\x00")); err != nil {
+		syscall.Close(fd)
+		return -1, fmt.Errorf("failed to register PSI trigger on %s: %w", file, err)
+	}
+	logger.Debug("PSI trigger is registered", slog.String("file", file), slog.String("trigger", trigger))
+
+	return fd, nil
+}
@@ -0,0 +1,172 @@
+package memlimit
+
+import (
+	"context"
+	"io/fs"
+	"math"
+	"runtime/debug"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestParsePressure(t *testing.T) {
+	got, err := parsePressure([]byte("" +
+		"some avg10=12.50 avg60=3.00 avg300=1.00 total=123456\n" +
+		"full avg10=4.25 avg60=1.00 avg300=0.50 total=23456\n"))
+	if err != nil {
+		t.Fatalf("parsePressure() error = %v", err)
+	}
+	if want := (psiStats{SomeAvg10: 12.5, FullAvg10: 4.25}); got != want {
+		t.Errorf("parsePressure() got = %+v, want %+v", got, want)
+	}
+
+	if _, err := parsePressure([]byte("some avg10=abc\n")); err == nil {
+		t.Errorf("parsePressure() error = %v, wantErr %v", err, true)
+	}
+}
+
+func TestMemoryPressureFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"proc/self/mountinfo":                         {Data: []byte("35 24 0:30 / /sys/fs/cgroup rw - cgroup2 cgroup2 rw\n")},
+		"proc/self/cgroup":                            {Data: []byte("0::/kubepods/pod1\n")},
+		"sys/fs/cgroup/kubepods/pod1/memory.pressure": {Data: []byte("some avg10=0.00 avg60=0.00 avg300=0.00 total=0\n")},
+	}
+	procFS, err := fs.Sub(fsys, "proc/self")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := memoryPressureFile(CgroupOptions{FS: fsys, ProcFS: procFS}); got != "/sys/fs/cgroup/kubepods/pod1/memory.pressure" {
+		t.Errorf("memoryPressureFile() got = %v, want %v", got, "/sys/fs/cgroup/kubepods/pod1/memory.pressure")
+	}
+
+	delete(fsys, "sys/fs/cgroup/kubepods/pod1/memory.pressure")
+	if got := memoryPressureFile(CgroupOptions{FS: fsys, ProcFS: procFS}); got != "/proc/pressure/memory" {
+		t.Errorf("memoryPressureFile() got = %v, want %v", got, "/proc/pressure/memory")
+	}
+}
+
+func TestApplyPressure(t *testing.T) {
+	policy := PressurePolicy{Floor: 0.8, Step: 0.1}.withDefaults()
+	factor := newPressureFactor()
+	provider := applyPressure(Limit(1000), policy, factor)
+
+	steps := []struct {
+		stats       psiStats
+		want        uint64
+		wantChanged bool
+	}{
+		{stats: psiStats{}, want: 1000},
+		{stats: psiStats{SomeAvg10: 20}, want: 900, wantChanged: true},
+		{stats: psiStats{FullAvg10: 10}, want: 800, wantChanged: true},
+		// the floor
+		{stats: psiStats{SomeAvg10: 50, FullAvg10: 50}, want: 800},
+		// relaxing
+		{stats: psiStats{SomeAvg10: 1}, want: 900, wantChanged: true},
+		{stats: psiStats{}, want: 1000, wantChanged: true},
+		{stats: psiStats{}, want: 1000},
+	}
+	for i, step := range steps {
+		if changed := factor.step(policy, step.stats); changed != step.wantChanged {
+			t.Errorf("step %d: step() got = %v, want %v", i, changed, step.wantChanged)
+		}
+		// the provider only reads the factor, so calling it repeatedly does not tighten the limit further.
+		for j := 0; j < 3; j++ {
+			got, err := provider()
+			if err != nil {
+				t.Fatalf("step %d: provider() error = %v", i, err)
+			}
+			if got != step.want {
+				t.Errorf("step %d: provider() got = %v, want %v", i, got, step.want)
+			}
+		}
+	}
+}
+
+func TestStart_WithPressureTightening(t *testing.T) {
+	t.Cleanup(func() {
+		debug.SetMemoryLimit(math.MaxInt64)
+	})
+
+	var high atomic.Bool
+	high.Store(true)
+	changes := make(chan Change, 16)
+	c, err := Start(context.Background(),
+		WithProvider(Limit(1000*1024*1024)),
+		WithRatio(1),
+		WithPressureTightening(PressurePolicy{Floor: 0.8, Step: 0.1, Interval: 10 * time.Millisecond}),
+		WithChangeChannel(changes),
+		func(cfg *config) {
+			cfg.psi = func(string) (psiStats, error) {
+				if high.Load() {
+					return psiStats{SomeAvg10: 50}, nil
+				}
+				return psiStats{}, nil
+			}
+		},
+	)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer c.Stop()
+	if change := <-changes; change.Source != SourceInit {
+		t.Fatalf("Change.Source got = %v, want %v", change.Source, SourceInit)
+	}
+
+	// GOMEMLIMIT is tightened by a step at each check down to the floor, and relaxed back once the pressure subsides.
+	for i, want := range []uint64{900 * 1024 * 1024, 800 * 1024 * 1024, 900 * 1024 * 1024, 1000 * 1024 * 1024} {
+		select {
+		case change := <-changes:
+			if change.Source != SourcePressure || change.New != want {
+				t.Fatalf("change %d: got = %+v, want Source = %v, New = %v", i, change, SourcePressure, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("change %d: no Change from the pressure monitor", i)
+		}
+		if i == 1 {
+			// refreshing does not tighten the limit below the floor, nor further on its own.
+			if got, err := c.Refresh(); err != nil || got != 800*1024*1024 {
+				t.Errorf("Refresh() got = %v, %v, want %v", got, err, 800*1024*1024)
+			}
+			high.Store(false)
+		}
+	}
+}
+
+func TestStart_WithPressureTightening_MinLimit(t *testing.T) {
+	t.Cleanup(func() {
+		debug.SetMemoryLimit(math.MaxInt64)
+	})
+
+	changes := make(chan Change, 16)
+	c, err := Start(context.Background(),
+		WithProvider(Limit(500*1024*1024)),
+		WithRatio(1),
+		WithMinLimit(1000*1024*1024),
+		WithPressureTightening(PressurePolicy{Floor: 0.8, Step: 0.1, Interval: 10 * time.Millisecond}),
+		WithChangeChannel(changes),
+		func(cfg *config) {
+			cfg.psi = func(string) (psiStats, error) {
+				return psiStats{SomeAvg10: 50}, nil
+			}
+		},
+	)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer c.Stop()
+	if change := <-changes; change.Source != SourceInit || change.New != 1000*1024*1024 {
+		t.Fatalf("change got = %+v, want Source = %v, New = %v", change, SourceInit, 1000*1024*1024)
+	}
+
+	// the pressure tightens the limit raised to the minimum, below the minimum.
+	select {
+	case change := <-changes:
+		if change.Source != SourcePressure || change.New != 900*1024*1024 {
+			t.Fatalf("change got = %+v, want Source = %v, New = %v", change, SourcePressure, 900*1024*1024)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no Change from the pressure monitor")
+	}
+}
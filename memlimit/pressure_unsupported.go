@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package memlimit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+func watchMemoryPressure(_ context.Context, _ *sync.WaitGroup, _ string, _ PressurePolicy, _ *slog.Logger) (<-chan struct{}, error) {
+	return nil, ErrCgroupsNotSupported
+}
@@ -1,7 +1,10 @@
 package memlimit
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
+	"sync/atomic"
 )
 
 // Provider is a function that returns the memory limit.
@@ -31,13 +34,241 @@ func ApplyRatio(provider Provider, ratio float64) Provider {
 	}
 }
 
+// FallbackOption configures when ApplyFallback uses the fallback provider.
+type FallbackOption func(cfg *fallbackConfig)
+
+type fallbackConfig struct {
+	onNoLimit bool
+	onFailure bool
+}
+
+// FallbackOnNoLimit makes ApplyFallback use the fallback provider only if the provider returns ErrNoLimit.
+// The other errors are returned as is.
+func FallbackOnNoLimit() FallbackOption {
+	return func(cfg *fallbackConfig) {
+		cfg.onNoLimit, cfg.onFailure = true, false
+	}
+}
+
+// FallbackOnFailure makes ApplyFallback use the fallback provider only if the provider fails with an error other than ErrNoLimit.
+// ErrNoLimit is returned as is.
+func FallbackOnFailure() FallbackOption {
+	return func(cfg *fallbackConfig) {
+		cfg.onNoLimit, cfg.onFailure = false, true
+	}
+}
+
 // ApplyFallback is a helper Provider function that sets the fallback provider.
-func ApplyFallback(provider Provider, fallback Provider) Provider {
+// By default, the fallback provider is used if the provider returns any error including ErrNoLimit.
+// See FallbackOnNoLimit and FallbackOnFailure to distinguish them.
+func ApplyFallback(provider Provider, fallback Provider, opts ...FallbackOption) Provider {
+	cfg := &fallbackConfig{onNoLimit: true, onFailure: true}
+	for _, opt := range opts {
+		opt(cfg)
+	}
 	return func() (uint64, error) {
 		limit, err := provider()
-		if err != nil {
+		if err == nil {
+			return limit, nil
+		}
+		if noLimit := errors.Is(err, ErrNoLimit); (noLimit && cfg.onNoLimit) || (!noLimit && cfg.onFailure) {
 			return fallback()
 		}
+		return 0, err
+	}
+}
+
+// Min is a helper Provider function that returns the smallest limit of the given providers,
+// e.g. the smaller of the cgroup limit and an operator-configured cap.
+// The providers returning ErrNoLimit are ignored, and ErrNoLimit is returned if all of them do.
+// If any provider fails with another error, the error is returned.
+func Min(providers ...Provider) Provider {
+	return func() (uint64, error) {
+		var (
+			limit   uint64
+			limited bool
+		)
+		for _, provider := range providers {
+			l, err := provider()
+			if errors.Is(err, ErrNoLimit) {
+				continue
+			} else if err != nil {
+				return 0, err
+			}
+			if !limited || l < limit {
+				limit, limited = l, true
+			}
+		}
+		if !limited {
+			return 0, ErrNoLimit
+		}
+		return limit, nil
+	}
+}
+
+// Max is a helper Provider function that returns the largest limit of the given providers.
+// ErrNoLimit is returned if any provider returns ErrNoLimit, since no limit is larger than any limit.
+// If any provider fails with another error, the error is returned.
+func Max(providers ...Provider) Provider {
+	return func() (uint64, error) {
+		var (
+			limit   uint64
+			noLimit = len(providers) == 0
+		)
+		for _, provider := range providers {
+			l, err := provider()
+			if errors.Is(err, ErrNoLimit) {
+				noLimit = true
+				continue
+			} else if err != nil {
+				return 0, err
+			}
+			limit = max(limit, l)
+		}
+		if noLimit {
+			return 0, ErrNoLimit
+		}
 		return limit, nil
 	}
 }
+
+// FirstOf is a helper Provider function that returns the limit of the first provider that succeeds.
+// It is a chain of ApplyFallback for three or more providers.
+// If all providers fail, the errors other than ErrNoLimit are joined and returned,
+// and ErrNoLimit is returned if there are no such errors.
+func FirstOf(providers ...Provider) Provider {
+	return func() (uint64, error) {
+		var errs []error
+		for _, provider := range providers {
+			limit, err := provider()
+			if err == nil {
+				return limit, nil
+			} else if !errors.Is(err, ErrNoLimit) {
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) == 0 {
+			return 0, ErrNoLimit
+		}
+		return 0, errors.Join(errs...)
+	}
+}
+
+// ApplyReservation is a helper Provider function that subtracts the given reservation in bytes from the given provider.
+// It is useful to reserve a fixed amount of memory for the memory sources the Go runtime is unaware of, such as cgo libraries.
+func ApplyReservation(provider Provider, reservation uint64) Provider {
+	if reservation == 0 {
+		return provider
+	}
+	return func() (uint64, error) {
+		limit, err := provider()
+		if err != nil {
+			return 0, err
+		}
+		if limit <= reservation {
+			return 0, fmt.Errorf("memory limit %d is not greater than the reservation %d", limit, reservation)
+		}
+		return limit - reservation, nil
+	}
+}
+
+// HeadroomPolicy is a policy that determines the headroom, the part of the memory limit not given to the Go runtime.
+// The headroom is limit*(1-Ratio) clamped to [MinReserve,MaxReserve].
+type HeadroomPolicy struct {
+	// Ratio is the ratio of the memory limit to set as GOMEMLIMIT in the half-open range (0.0,1.0].
+	Ratio float64
+	// MinReserve is the minimum headroom in bytes.
+	MinReserve uint64
+	// MaxReserve is the maximum headroom in bytes. 0 means no maximum.
+	MaxReserve uint64
+}
+
+func (p HeadroomPolicy) withDefaults() HeadroomPolicy {
+	if p.Ratio == 0 {
+		p.Ratio = defaultAUTOMEMLIMIT
+	}
+	return p
+}
+
+// ApplyHeadroom is a helper Provider function that applies the given headroom policy to the given provider.
+// If neither MinReserve nor MaxReserve is set, it is equivalent to ApplyRatio.
+func ApplyHeadroom(provider Provider, policy HeadroomPolicy) Provider {
+	if policy.MinReserve == 0 && policy.MaxReserve == 0 {
+		return ApplyRatio(provider, policy.Ratio)
+	}
+	return func() (uint64, error) {
+		if policy.Ratio <= 0 || policy.Ratio > 1 {
+			return 0, fmt.Errorf("invalid ratio: %f, ratio should be in the range (0.0,1.0]", policy.Ratio)
+		}
+		if policy.MaxReserve != 0 && policy.MaxReserve < policy.MinReserve {
+			return 0, fmt.Errorf("invalid headroom policy: max reserve %d is smaller than min reserve %d", policy.MaxReserve, policy.MinReserve)
+		}
+		limit, err := provider()
+		if err != nil {
+			return 0, err
+		}
+
+		headroom := uint64(float64(limit) * (1 - policy.Ratio))
+		if policy.MaxReserve != 0 {
+			headroom = min(headroom, policy.MaxReserve)
+		}
+		headroom = max(headroom, policy.MinReserve)
+		if limit <= headroom {
+			return 0, fmt.Errorf("memory limit %d is not greater than the headroom %d", limit, headroom)
+		}
+
+		return limit - headroom, nil
+	}
+}
+
+// ApplyBounds is a helper Provider function that clamps the limit of the given provider to [min,max].
+// It guards against a misconfigured memory limit, e.g. a tiny cgroup limit that makes the GC run constantly.
+// 0 means no bound. If the provider returns ErrNoLimit, max is returned as the limit,
+// or ErrNoLimit is returned as is if max is 0.
+// A warning is logged to the given logger when the limit is clamped. The logger can be nil.
+func ApplyBounds(provider Provider, min, max uint64, logger *slog.Logger) Provider {
+	return applyBounds(provider, min, max, memlimitLogger(logger))
+}
+
+// applyBounds is ApplyBounds that logs a warning when the limit is clamped.
+// The warning is logged once for each clamped limit, so that it is not repeated on every refresh.
+// The fallback to the maximum limit is logged once when the provider starts reporting no limit.
+func applyBounds(provider Provider, minLimit, maxLimit uint64, logger *slog.Logger) Provider {
+	if minLimit == 0 && maxLimit == 0 {
+		return provider
+	}
+	var (
+		lastWarned atomic.Uint64
+		noLimit    atomic.Bool
+	)
+	return func() (uint64, error) {
+		if maxLimit != 0 && maxLimit < minLimit {
+			return 0, fmt.Errorf("invalid bounds: max limit %d is smaller than min limit %d", maxLimit, minLimit)
+		}
+		limit, err := provider()
+		if errors.Is(err, ErrNoLimit) && maxLimit != 0 {
+			if !noLimit.Swap(true) {
+				logger.Info("memory is not limited, using the maximum limit", slog.Uint64("max", maxLimit))
+			}
+			return maxLimit, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		noLimit.Store(false)
+
+		bounded := max(limit, minLimit)
+		if maxLimit != 0 {
+			bounded = min(bounded, maxLimit)
+		}
+		if bounded != limit && lastWarned.Swap(limit) != limit {
+			logger.Warn("memory limit is out of bounds, clamping",
+				slog.Uint64("limit", limit),
+				slog.Uint64("clamped", bounded),
+				slog.Uint64("min", minLimit),
+				slog.Uint64("max", maxLimit),
+			)
+		}
+		return bounded, nil
+	}
+}
@@ -0,0 +1,238 @@
+package memlimit
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestApplyReservation(t *testing.T) {
+	tests := []struct {
+		name        string
+		provider    Provider
+		reservation uint64
+		want        uint64
+		wantErr     error
+	}{
+		{
+			name:        "1gib-64mib",
+			provider:    Limit(1 << 30),
+			reservation: 64 << 20,
+			want:        1<<30 - 64<<20,
+		},
+		{
+			name:        "no reservation",
+			provider:    Limit(1 << 30),
+			reservation: 0,
+			want:        1 << 30,
+		},
+		{
+			name:        "too large reservation",
+			provider:    Limit(64 << 20),
+			reservation: 64 << 20,
+			wantErr:     fmt.Errorf("memory limit 67108864 is not greater than the reservation 67108864"),
+		},
+		{
+			name: "ErrNoLimit",
+			provider: func() (uint64, error) {
+				return 0, ErrNoLimit
+			},
+			reservation: 64 << 20,
+			wantErr:     ErrNoLimit,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyReservation(tt.provider, tt.reservation)()
+			if fmt.Sprint(err) != fmt.Sprint(tt.wantErr) {
+				t.Errorf("ApplyReservation() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ApplyReservation() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyHeadroom(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		policy   HeadroomPolicy
+		want     uint64
+		wantErr  error
+	}{
+		{
+			name:     "ratio only",
+			provider: Limit(1000),
+			policy:   HeadroomPolicy{Ratio: 0.9},
+			want:     900,
+		},
+		{
+			name:     "min reserve",
+			provider: Limit(256 << 20),
+			policy:   HeadroomPolicy{Ratio: 0.9, MinReserve: 64 << 20},
+			want:     192 << 20,
+		},
+		{
+			name:     "max reserve",
+			provider: Limit(64 << 30),
+			policy:   HeadroomPolicy{Ratio: 0.9, MaxReserve: 1 << 30},
+			want:     63 << 30,
+		},
+		{
+			name:     "between min and max",
+			provider: Limit(1 << 30),
+			policy:   HeadroomPolicy{Ratio: 0.75, MinReserve: 64 << 20, MaxReserve: 1 << 30},
+			want:     768 << 20,
+		},
+		{
+			name:     "max uint64",
+			provider: Limit(math.MaxUint64),
+			policy:   HeadroomPolicy{Ratio: 0.9, MaxReserve: 1 << 30},
+			want:     math.MaxUint64 - 1<<30,
+		},
+		{
+			name:     "headroom larger than limit",
+			provider: Limit(32 << 20),
+			policy:   HeadroomPolicy{Ratio: 0.9, MinReserve: 64 << 20},
+			wantErr:  fmt.Errorf("memory limit 33554432 is not greater than the headroom 67108864"),
+		},
+		{
+			name:     "invalid ratio",
+			provider: Limit(1 << 30),
+			policy:   HeadroomPolicy{Ratio: 1.5, MinReserve: 64 << 20},
+			wantErr:  fmt.Errorf("invalid ratio: 1.500000, ratio should be in the range (0.0,1.0]"),
+		},
+		{
+			name:     "max smaller than min",
+			provider: Limit(1 << 30),
+			policy:   HeadroomPolicy{Ratio: 0.9, MinReserve: 2 << 20, MaxReserve: 1 << 20},
+			wantErr:  fmt.Errorf("invalid headroom policy: max reserve 1048576 is smaller than min reserve 2097152"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyHeadroom(tt.provider, tt.policy)()
+			if fmt.Sprint(err) != fmt.Sprint(tt.wantErr) {
+				t.Errorf("ApplyHeadroom() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ApplyHeadroom() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComposeProviders(t *testing.T) {
+	var (
+		errFailed = errors.New("failed")
+		noLimit   = func() (uint64, error) { return 0, ErrNoLimit }
+		failed    = func() (uint64, error) { return 0, errFailed }
+	)
+	tests := []struct {
+		name     string
+		provider Provider
+		want     uint64
+		wantErr  error
+	}{
+		{name: "ApplyFallback success", provider: ApplyFallback(Limit(1), Limit(2)), want: 1},
+		{name: "ApplyFallback no limit", provider: ApplyFallback(noLimit, Limit(2)), want: 2},
+		{name: "ApplyFallback failure", provider: ApplyFallback(failed, Limit(2)), want: 2},
+		{name: "ApplyFallback on no limit", provider: ApplyFallback(noLimit, Limit(2), FallbackOnNoLimit()), want: 2},
+		{name: "ApplyFallback on no limit failure", provider: ApplyFallback(failed, Limit(2), FallbackOnNoLimit()), wantErr: errFailed},
+		{name: "ApplyFallback on failure", provider: ApplyFallback(failed, Limit(2), FallbackOnFailure()), want: 2},
+		{name: "ApplyFallback on failure no limit", provider: ApplyFallback(noLimit, Limit(2), FallbackOnFailure()), wantErr: ErrNoLimit},
+		{name: "Min", provider: Min(Limit(3), Limit(1), Limit(2)), want: 1},
+		{name: "Min no limit", provider: Min(noLimit, Limit(2)), want: 2},
+		{name: "Min all no limit", provider: Min(noLimit, noLimit), wantErr: ErrNoLimit},
+		{name: "Min empty", provider: Min(), wantErr: ErrNoLimit},
+		{name: "Min failure", provider: Min(Limit(1), failed), wantErr: errFailed},
+		{name: "Max", provider: Max(Limit(1), Limit(3), Limit(2)), want: 3},
+		{name: "Max no limit", provider: Max(Limit(1), noLimit), wantErr: ErrNoLimit},
+		{name: "Max empty", provider: Max(), wantErr: ErrNoLimit},
+		{name: "Max failure", provider: Max(Limit(1), failed), wantErr: errFailed},
+		{name: "FirstOf", provider: FirstOf(noLimit, failed, Limit(3), Limit(4)), want: 3},
+		{name: "FirstOf all no limit", provider: FirstOf(noLimit, noLimit), wantErr: ErrNoLimit},
+		{name: "FirstOf empty", provider: FirstOf(), wantErr: ErrNoLimit},
+		{name: "FirstOf failure", provider: FirstOf(noLimit, failed), wantErr: errFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.provider()
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("provider() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("provider() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyBounds(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		min, max uint64
+		want     uint64
+		wantErr  error
+	}{
+		{name: "within bounds", provider: Limit(1 << 30), min: 64 << 20, max: 2 << 30, want: 1 << 30},
+		{name: "below min", provider: Limit(16 << 20), min: 64 << 20, want: 64 << 20},
+		{name: "above max", provider: Limit(4 << 30), max: 2 << 30, want: 2 << 30},
+		{name: "no bounds", provider: Limit(16 << 20), want: 16 << 20},
+		{
+			name:     "ErrNoLimit",
+			provider: func() (uint64, error) { return 0, ErrNoLimit },
+			min:      64 << 20,
+			wantErr:  ErrNoLimit,
+		},
+		{
+			name:     "ErrNoLimit with max",
+			provider: func() (uint64, error) { return 0, ErrNoLimit },
+			min:      64 << 20,
+			max:      2 << 30,
+			want:     2 << 30,
+		},
+		{
+			name:     "max smaller than min",
+			provider: Limit(1 << 30),
+			min:      2 << 20,
+			max:      1 << 20,
+			wantErr:  fmt.Errorf("invalid bounds: max limit 1048576 is smaller than min limit 2097152"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ApplyBounds(tt.provider, tt.min, tt.max, nil)()
+			if fmt.Sprint(err) != fmt.Sprint(tt.wantErr) {
+				t.Errorf("ApplyBounds() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ApplyBounds() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyBounds_Logger(t *testing.T) {
+	recorder := &messageRecorder{}
+	provider := ApplyBounds(Limit(16<<20), 64<<20, 0, slog.New(recorder))
+	for i := 0; i < 2; i++ {
+		if got, err := provider(); err != nil || got != 64<<20 {
+			t.Fatalf("ApplyBounds() got = %v, %v, want %v", got, err, 64<<20)
+		}
+	}
+	// the warning is logged once for the clamped limit.
+	if want := []string{"memory limit is out of bounds, clamping"}; !reflect.DeepEqual(recorder.messages, want) {
+		t.Errorf("messages got = %v, want %v", recorder.messages, want)
+	}
+}
@@ -0,0 +1,120 @@
+package memlimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits are the units accepted by parseSize.
+// The units of GOMEMLIMIT (B, KiB, MiB, GiB, TiB) and their short forms (Ki, Mi, Gi, Ti) are accepted.
+var sizeUnits = []struct {
+	suffix string
+	scale  uint64
+}{
+	// longer suffixes first, so that "KiB" is not matched as "B".
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"TiB", 1 << 40},
+	{"Ki", 1 << 10},
+	{"Mi", 1 << 20},
+	{"Gi", 1 << 30},
+	{"Ti", 1 << 40},
+	{"B", 1},
+}
+
+// parseSize parses a size in bytes with an optional unit, such as "64MiB", "1Gi" or "1.5GiB".
+func parseSize(s string) (uint64, error) {
+	num, scale := s, uint64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			num, scale = strings.TrimSuffix(s, u.suffix), u.scale
+			break
+		}
+	}
+
+	if n, err := strconv.ParseUint(num, 10, 64); err == nil {
+		if n > math.MaxUint64/scale {
+			return 0, fmt.Errorf("invalid size %q: overflow", s)
+		}
+		return n * scale, nil
+	}
+
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	size := f * float64(scale)
+	if size >= math.MaxUint64 {
+		return 0, fmt.Errorf("invalid size %q: overflow", s)
+	}
+
+	return uint64(size), nil
+}
+
+// quantitySuffixes are the suffixes of the Kubernetes resource quantity accepted by parseQuantity.
+var quantitySuffixes = []struct {
+	suffix string
+	scale  float64
+}{
+	// binary suffixes first, so that "Mi" is not matched as "M".
+	{"Ki", 1 << 10},
+	{"Mi", 1 << 20},
+	{"Gi", 1 << 30},
+	{"Ti", 1 << 40},
+	{"Pi", 1 << 50},
+	{"Ei", 1 << 60},
+	{"n", 1e-9},
+	{"u", 1e-6},
+	{"m", 1e-3},
+	{"k", 1e3},
+	{"M", 1e6},
+	{"G", 1e9},
+	{"T", 1e12},
+	{"P", 1e15},
+	{"E", 1e18},
+}
+
+// parseQuantity parses a Kubernetes resource quantity in bytes, such as "512Mi", "1G" or "2e9".
+// Fractional bytes are rounded up as Kubernetes does.
+func parseQuantity(s string) (uint64, error) {
+	num, scale := s, 1.0
+	for _, u := range quantitySuffixes {
+		if strings.HasSuffix(s, u.suffix) {
+			num, scale = strings.TrimSuffix(s, u.suffix), u.scale
+			break
+		}
+	}
+	// decimal exponent, e.g. "2e9". "E" without an exponent is the exa suffix.
+	if i := strings.IndexAny(s, "eE"); i > 0 {
+		if exp, err := strconv.Atoi(s[i+1:]); err == nil {
+			num, scale = s[:i], math.Pow10(exp)
+		}
+	}
+
+	// the number must be decimal, so that a suffix is not combined with an exponent (e.g. "1e3k"),
+	// and the other forms accepted by strconv.ParseFloat (e.g. "Inf" or "0x1p3") are rejected.
+	if strings.TrimLeft(strings.TrimPrefix(num, "+"), "0123456789.") != "" {
+		return 0, fmt.Errorf("invalid quantity %q", s)
+	}
+
+	if n, err := strconv.ParseUint(num, 10, 64); err == nil && scale >= 1 && scale < math.MaxUint64 {
+		if n > math.MaxUint64/uint64(scale) {
+			return 0, fmt.Errorf("invalid quantity %q: overflow", s)
+		}
+		return n * uint64(scale), nil
+	}
+
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("invalid quantity %q", s)
+	}
+	size := math.Ceil(f * scale)
+	if size >= math.MaxUint64 {
+		return 0, fmt.Errorf("invalid quantity %q: overflow", s)
+	}
+
+	return uint64(size), nil
+}
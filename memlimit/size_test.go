@@ -0,0 +1,86 @@
+package memlimit
+
+import (
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    uint64
+		wantErr bool
+	}{
+		{input: "0", want: 0},
+		{input: "1024", want: 1024},
+		{input: "1024B", want: 1024},
+		{input: "64Ki", want: 64 << 10},
+		{input: "64KiB", want: 64 << 10},
+		{input: "64Mi", want: 64 << 20},
+		{input: "64MiB", want: 64 << 20},
+		{input: "1Gi", want: 1 << 30},
+		{input: "1.5GiB", want: 3 << 29},
+		{input: "2TiB", want: 2 << 40},
+		{input: "", wantErr: true},
+		{input: "MiB", wantErr: true},
+		{input: "-1MiB", wantErr: true},
+		{input: "1XB", wantErr: true},
+		{input: "16777216TiB", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseSize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseSize() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    uint64
+		wantErr bool
+	}{
+		{input: "0", want: 0},
+		{input: "536870912", want: 512 << 20},
+		{input: "512Mi", want: 512 << 20},
+		{input: "1.5Gi", want: 3 << 29},
+		{input: "2Ei", want: 2 << 60},
+		{input: "1k", want: 1000},
+		{input: "1G", want: 1000000000},
+		{input: "1.5G", want: 1500000000},
+		{input: "1E", want: 1000000000000000000},
+		{input: "2e9", want: 2000000000},
+		{input: "2E9", want: 2000000000},
+		{input: "1.5e3", want: 1500},
+		{input: "1500m", want: 2},
+		{input: "", wantErr: true},
+		{input: "Mi", wantErr: true},
+		{input: "-1Mi", wantErr: true},
+		{input: "1MiB", wantErr: true},
+		{input: "1e", wantErr: true},
+		{input: "16Ei", wantErr: true},
+		{input: "1e20", wantErr: true},
+		{input: "1e3k", wantErr: true},
+		{input: "2e3Mi", wantErr: true},
+		{input: "Inf", wantErr: true},
+		{input: "0x1p3", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseQuantity(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseQuantity() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseQuantity() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package memlimit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Result is the memory limit reported by a ContextProvider.
+type Result struct {
+	// Value is the memory limit in bytes.
+	Value uint64
+	// Source is where the limit was read from, e.g. the path of the file or the name of the environment variable.
+	// For the ContextProvider returned by NewContextProvider, it is the given name.
+	Source string
+	// Raw is the value as read from the source before it was parsed, e.g. "512Mi".
+	// It is empty if not available.
+	Raw string
+}
+
+// ContextProvider is a context-aware variant of Provider.
+// Unlike Provider, it can be cancelled or time out, and it reports where the limit came from.
+// It returns ErrNoLimit if the memory is not limited, in the same way as Provider.
+//
+// Use NewContextProvider and FromContextProvider to convert between Provider and ContextProvider.
+type ContextProvider interface {
+	Limit(ctx context.Context) (Result, error)
+}
+
+// ContextProviderFunc is an adapter to use an ordinary function as a ContextProvider.
+type ContextProviderFunc func(ctx context.Context) (Result, error)
+
+// Limit calls f(ctx).
+func (f ContextProviderFunc) Limit(ctx context.Context) (Result, error) {
+	return f(ctx)
+}
+
+// NewContextProvider returns a ContextProvider that reports the limit of the given provider with the given name.
+// Since a Provider cannot be cancelled, it is called in a separate goroutine,
+// and Limit returns the error of the context as soon as the context is done.
+// The goroutine exits when the provider returns, e.g. when a hung read of the cgroupfs completes.
+// At most one call of the provider is in flight: while it has not returned,
+// Limit waits for the result of the same call instead of calling the provider again.
+//
+// Result.Raw is always empty, since a Provider only returns the parsed limit.
+func NewContextProvider(name string, provider Provider) ContextProvider {
+	type call struct {
+		done  chan struct{}
+		limit uint64
+		err   error
+	}
+	var (
+		mu       sync.Mutex
+		inflight *call
+	)
+	return ContextProviderFunc(func(ctx context.Context) (Result, error) {
+		mu.Lock()
+		cl := inflight
+		if cl == nil {
+			cl = &call{done: make(chan struct{})}
+			inflight = cl
+			go func() {
+				cl.limit, cl.err = provider()
+				mu.Lock()
+				inflight = nil
+				mu.Unlock()
+				close(cl.done)
+			}()
+		}
+		mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return Result{}, ctx.Err()
+		case <-cl.done:
+			if cl.err != nil {
+				return Result{}, cl.err
+			}
+			return Result{Value: cl.limit, Source: name}, nil
+		}
+	})
+}
+
+// FromContextProvider returns a Provider that reports the limit of the given ContextProvider.
+// Use ApplyTimeout to bound how long the provider can block.
+func FromContextProvider(cp ContextProvider) Provider {
+	return fromContextProvider(context.Background(), cp, slog.New(noopLogger{}))
+}
+
+// fromContextProvider is FromContextProvider that passes the given context to cp and logs the result.
+func fromContextProvider(ctx context.Context, cp ContextProvider, logger *slog.Logger) Provider {
+	return func() (uint64, error) {
+		res, err := cp.Limit(ctx)
+		if err != nil {
+			return 0, err
+		}
+		logger.Debug("memory limit is reported",
+			slog.Uint64("limit", res.Value),
+			slog.String("source", res.Source),
+			slog.String("raw", res.Raw),
+		)
+		return res.Value, nil
+	}
+}
+
+// ApplyTimeout returns a ContextProvider that fails with context.DeadlineExceeded
+// if the given ContextProvider does not report the limit within the timeout.
+func ApplyTimeout(cp ContextProvider, timeout time.Duration) ContextProvider {
+	return ContextProviderFunc(func(ctx context.Context) (Result, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return cp.Limit(ctx)
+	})
+}
@@ -0,0 +1,123 @@
+package memlimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"runtime/debug"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewContextProvider(t *testing.T) {
+	got, err := NewContextProvider("static", Limit(1<<30)).Limit(context.Background())
+	if err != nil {
+		t.Fatalf("Limit() error = %v", err)
+	}
+	want := Result{Value: 1 << 30, Source: "static"}
+	if got != want {
+		t.Errorf("Limit() got = %+v, want %+v", got, want)
+	}
+
+	_, err = NewContextProvider("none", func() (uint64, error) {
+		return 0, ErrNoLimit
+	}).Limit(context.Background())
+	if err != ErrNoLimit {
+		t.Errorf("Limit() error = %v, want %v", err, ErrNoLimit)
+	}
+}
+
+func TestApplyTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	hung := NewContextProvider("hung", func() (uint64, error) {
+		<-release
+		return 1 << 30, nil
+	})
+	_, err := FromContextProvider(ApplyTimeout(hung, 10*time.Millisecond))()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("FromContextProvider() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestNewContextProvider_SingleFlight(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	hung := NewContextProvider("hung", func() (uint64, error) {
+		calls.Add(1)
+		<-release
+		return 1 << 30, nil
+	})
+
+	// the calls timing out while the provider is hung share the same call.
+	for i := 0; i < 3; i++ {
+		_, err := ApplyTimeout(hung, 10*time.Millisecond).Limit(context.Background())
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Limit() error = %v, want %v", err, context.DeadlineExceeded)
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("provider calls got = %v, want %v", got, 1)
+	}
+
+	close(release)
+	got, err := hung.Limit(context.Background())
+	if err != nil {
+		t.Fatalf("Limit() error = %v", err)
+	}
+	if got.Value != 1<<30 {
+		t.Errorf("Limit() got = %v, want %v", got.Value, 1<<30)
+	}
+
+	// the provider is called again once the previous call has returned.
+	if _, err := hung.Limit(context.Background()); err != nil {
+		t.Fatalf("Limit() error = %v", err)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("provider calls got = %v, want %v", got, 2)
+	}
+}
+
+func TestStart_WithContextProvider_Stop(t *testing.T) {
+	t.Cleanup(func() {
+		debug.SetMemoryLimit(math.MaxInt64)
+	})
+
+	var ctxs []context.Context
+	cp := ContextProviderFunc(func(ctx context.Context) (Result, error) {
+		ctxs = append(ctxs, ctx)
+		return Result{Value: 1 << 30, Source: "test"}, nil
+	})
+	c, err := Start(context.Background(), WithContextProvider(cp), WithRatio(1))
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	c.Stop()
+
+	// the ContextProvider is called with the context of the Controller, which is cancelled by Stop.
+	if len(ctxs) != 1 {
+		t.Fatalf("Limit calls got = %v, want %v", len(ctxs), 1)
+	}
+	if err := ctxs[0].Err(); err != context.Canceled {
+		t.Errorf("ctx.Err() got = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestSetGoMemLimitWithOpts_WithContextProvider(t *testing.T) {
+	t.Cleanup(func() {
+		debug.SetMemoryLimit(math.MaxInt64)
+	})
+
+	cp := ContextProviderFunc(func(ctx context.Context) (Result, error) {
+		return Result{Value: 1 << 30, Source: "test", Raw: "1Gi"}, nil
+	})
+	got, err := SetGoMemLimitWithOpts(WithContextProvider(cp), WithRatio(0.5))
+	if err != nil {
+		t.Fatalf("SetGoMemLimitWithOpts() error = %v", err)
+	}
+	if got != 512<<20 {
+		t.Errorf("SetGoMemLimitWithOpts() got = %v, want %v", got, 512<<20)
+	}
+}
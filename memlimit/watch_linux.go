@@ -0,0 +1,84 @@
+//go:build linux
+// +build linux
+
+package memlimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// watchMemoryLimit watches the memory limit files (and directories) returned by limitFiles with inotify.
+// It sends to the returned channel when the files are modified.
+// The channel is closed when the context is done or watching fails.
+// The goroutine reading the events is added to wg.
+func watchMemoryLimit(ctx context.Context, wg *sync.WaitGroup, limitFiles func() ([]string, error), logger *slog.Logger) (<-chan struct{}, error) {
+	files, err := limitFiles()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find memory limit files: %w", err)
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize inotify: %w", err)
+	}
+	// since the fd is non-blocking, the file is registered to the runtime poller,
+	// and closing the file unblocks the pending read.
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	var watched int
+	for _, file := range files {
+		// IN_CREATE and IN_MOVED_TO are only reported for directories, when a file in them is created or replaced.
+		_, err := syscall.InotifyAddWatch(fd, file, syscall.IN_MODIFY|syscall.IN_ATTRIB|syscall.IN_CLOSE_WRITE|syscall.IN_CREATE|syscall.IN_MOVED_TO)
+		if err != nil {
+			if errors.Is(err, syscall.ENOENT) {
+				continue
+			}
+			f.Close()
+			return nil, fmt.Errorf("failed to watch %s: %w", file, err)
+		}
+		logger.Debug("watching memory limit file", slog.String("file", file))
+		watched++
+	}
+	if watched == 0 {
+		f.Close()
+		return nil, errors.New("no memory limit file to watch")
+	}
+
+	ch := make(chan struct{}, 1)
+	stop := context.AfterFunc(ctx, func() {
+		f.Close()
+	})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(ch)
+		defer stop()
+
+		buf := make([]byte, 4096)
+		for {
+			// the content of the events is not needed,
+			// since every event leads to reading the limit from the provider.
+			_, err := f.Read(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Error("failed to read inotify events", slog.Any("error", err))
+					f.Close()
+				}
+				return
+			}
+
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return ch, nil
+}
@@ -0,0 +1,109 @@
+// Package otelmetrics reports the state of automemlimit as OpenTelemetry metrics.
+//
+//	m, err := otelmetrics.New(otel.GetMeterProvider())
+//	if err != nil {
+//		// handle error
+//	}
+//	memlimit.SetGoMemLimitWithOpts(
+//		m.Option(),
+//		memlimit.WithRefreshInterval(1*time.Minute),
+//	)
+package otelmetrics
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/KimMachineGun/automemlimit/memlimit"
+	"github.com/KimMachineGun/automemlimit/memlimit/metrics"
+)
+
+// ScopeName is the instrumentation scope name of the meter.
+const ScopeName = "github.com/KimMachineGun/automemlimit/otelmetrics"
+
+// Metrics reports the state of automemlimit to the asynchronous instruments.
+// It is safe for concurrent use.
+type Metrics struct {
+	mu             sync.Mutex
+	containerLimit int64
+	limit          int64
+	failures       map[string]int64
+	observed       bool
+}
+
+// New registers the asynchronous instruments against the given meter provider.
+//
+// The following instruments are registered:
+//   - automemlimit.container.limit: the memory limit returned by the provider before the ratio was applied
+//   - automemlimit.gomemlimit: GOMEMLIMIT after the last update
+//   - automemlimit.refresh.failures: the number of failed attempts to update GOMEMLIMIT by error.kind
+func New(mp metric.MeterProvider) (*Metrics, error) {
+	m := &Metrics{
+		failures: make(map[string]int64),
+	}
+
+	meter := mp.Meter(ScopeName)
+	containerLimit, err := meter.Int64ObservableGauge("automemlimit.container.limit",
+		metric.WithUnit("By"),
+		metric.WithDescription("Memory limit returned by the provider before the ratio was applied. 0 if not limited."),
+	)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := meter.Int64ObservableGauge("automemlimit.gomemlimit",
+		metric.WithUnit("By"),
+		metric.WithDescription("GOMEMLIMIT after the last update."),
+	)
+	if err != nil {
+		return nil, err
+	}
+	failures, err := meter.Int64ObservableCounter("automemlimit.refresh.failures",
+		metric.WithUnit("{failure}"),
+		metric.WithDescription("Number of failed attempts to update GOMEMLIMIT."),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		if !m.observed {
+			return nil
+		}
+		o.ObserveInt64(containerLimit, m.containerLimit)
+		o.ObserveInt64(limit, m.limit)
+		for kind, n := range m.failures {
+			o.ObserveInt64(failures, n, metric.WithAttributes(attribute.String("error.kind", kind)))
+		}
+		return nil
+	}, containerLimit, limit, failures)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Option returns a memlimit.Option that reports the updates to the Metrics.
+func (m *Metrics) Option() memlimit.Option {
+	return memlimit.WithOnUpdate(m.Observe)
+}
+
+// Observe records the given update.
+func (m *Metrics) Observe(u memlimit.Update) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.observed = true
+	m.limit = int64(u.Limit)
+	if u.Err != nil {
+		m.failures[metrics.ErrorKind(u.Err)]++
+		return
+	}
+	m.containerLimit = int64(min(u.Raw, 1<<63-1))
+}
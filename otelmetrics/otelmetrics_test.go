@@ -0,0 +1,88 @@
+package otelmetrics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime/debug"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/KimMachineGun/automemlimit/memlimit"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Cleanup(func() {
+		debug.SetMemoryLimit(math.MaxInt64)
+	})
+
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	m, err := New(mp)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	c, err := memlimit.Start(context.Background(),
+		m.Option(),
+		memlimit.WithProvider(memlimit.Limit(1<<30)),
+		memlimit.WithRatio(0.5),
+	)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer c.Stop()
+	m.Observe(memlimit.Update{
+		Source: memlimit.SourceRefresh,
+		Limit:  1 << 29,
+		Err:    fmt.Errorf("wrapped: %w", memlimit.ErrNoCgroup),
+	})
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	if len(rm.ScopeMetrics) != 1 || rm.ScopeMetrics[0].Scope.Name != ScopeName {
+		t.Fatalf("ScopeMetrics got = %+v", rm.ScopeMetrics)
+	}
+
+	got := make(map[string]metricdata.DataPoint[int64])
+	for _, metric := range rm.ScopeMetrics[0].Metrics {
+		switch data := metric.Data.(type) {
+		case metricdata.Gauge[int64]:
+			for _, dp := range data.DataPoints {
+				got[metric.Name] = dp
+			}
+		case metricdata.Sum[int64]:
+			for _, dp := range data.DataPoints {
+				got[metric.Name] = dp
+			}
+		default:
+			t.Errorf("unexpected data type %T for %s", data, metric.Name)
+		}
+	}
+
+	want := map[string]int64{
+		"automemlimit.container.limit":  1 << 30,
+		"automemlimit.gomemlimit":       1 << 29,
+		"automemlimit.refresh.failures": 1,
+	}
+	for name, value := range want {
+		dp, ok := got[name]
+		if !ok {
+			t.Errorf("%s is not collected", name)
+			continue
+		}
+		if dp.Value != value {
+			t.Errorf("%s got = %v, want %v", name, dp.Value, value)
+		}
+	}
+	failures := got["automemlimit.refresh.failures"]
+	if kind, _ := failures.Attributes.Value(attribute.Key("error.kind")); kind.AsString() != "no_cgroup" {
+		t.Errorf("error.kind got = %v, want %v", kind.AsString(), "no_cgroup")
+	}
+}